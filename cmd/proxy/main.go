@@ -14,11 +14,12 @@ import (
 	"syscall"
 	"time"
 
+	"github.com/joao-brasil/poc-connection-pooling/internal/agent"
+	"github.com/joao-brasil/poc-connection-pooling/internal/browser"
 	"github.com/joao-brasil/poc-connection-pooling/internal/config"
 	"github.com/joao-brasil/poc-connection-pooling/internal/coordinator"
 	"github.com/joao-brasil/poc-connection-pooling/internal/health"
 	"github.com/joao-brasil/poc-connection-pooling/internal/metrics"
-	"github.com/joao-brasil/poc-connection-pooling/internal/pool"
 	"github.com/joao-brasil/poc-connection-pooling/internal/proxy"
 	"github.com/joao-brasil/poc-connection-pooling/internal/queue"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
@@ -27,6 +28,10 @@ import (
 var (
 	proxyConfigPath   = flag.String("config", "configs/proxy.yaml", "Path to proxy configuration file")
 	bucketsConfigPath = flag.String("buckets", "configs/buckets.yaml", "Path to buckets configuration file")
+
+	// backendTLSSkipVerify liga proxy.backend_tls_skip_verify sem editar o
+	// arquivo de configuração, para o ambiente local do docker-compose.
+	backendTLSSkipVerify = flag.Bool("backend-tls-skip-verify", false, "Skip backend TLS certificate verification (self-signed test servers only)")
 )
 
 func main() {
@@ -41,10 +46,13 @@ func main() {
 		log.Fatalf("[main] Failed to load configuration: %v", err)
 	}
 	log.Printf("[main] Configuration loaded: %d buckets, instance=%s", len(cfg.Buckets), cfg.Proxy.InstanceID)
+	if *backendTLSSkipVerify {
+		cfg.Proxy.BackendTLSSkipVerify = true
+	}
 
 	for _, b := range cfg.Buckets {
-		log.Printf("[main]   Bucket %s → %s:%d (max_conn=%d, min_idle=%d)",
-			b.ID, b.Host, b.Port, b.MaxConnections, b.MinIdle)
+		log.Printf("[main]   Bucket %s → %s:%d (max_conn=%d)",
+			b.ID, b.Host, b.Port, b.MaxConnections)
 	}
 
 	// ─── Inicializar Métricas ────────────────────────────────────────
@@ -90,23 +98,6 @@ func main() {
 	}
 	log.Printf("[main] Overall health: %s", report.Status)
 
-	// ─── Fase 1 — Inicializar Gerenciador de Connection Pool ────────
-	log.Println("[main] Initializing connection pool manager...")
-	poolMgr, err := pool.NewManager(context.Background(), cfg)
-	if err != nil {
-		log.Fatalf("[main] Failed to initialize pool manager: %v", err)
-	}
-	defer func() {
-		log.Println("[main] Closing pool manager...")
-		if err := poolMgr.Close(); err != nil {
-			log.Printf("[main] Pool manager close error: %v", err)
-		}
-	}()
-	log.Println("[main] Pool manager ready")
-	for _, s := range poolMgr.Stats() {
-		log.Printf("[main]   Pool %s: idle=%d, active=%d, max=%d", s.BucketID, s.Idle, s.Active, s.Max)
-	}
-
 	// ─── Fase 3 — Inicializar Coordenador Redis ─────────────────────
 	log.Println("[main] Initializing Redis coordinator...")
 	rc, err := coordinator.NewRedisCoordinator(context.Background(), cfg)
@@ -138,7 +129,24 @@ func main() {
 		cfg.Proxy.QueueTimeout, cfg.Proxy.MaxQueueSize)
 
 	// ─── Fase 2 — Inicializar Proxy TDS ─────────────────────────────
-	proxyServer := proxy.NewServer(cfg, poolMgr, rc, dq)
+	proxyServer := proxy.NewServer(cfg, rc, dq)
+
+	// ─── Agent-check do HAProxy (opcional) ──────────────────────────
+	// Iniciado antes do proxy e parado depois dele, para continuar
+	// respondendo "drain" enquanto as sessões terminam.
+	var agentResponder *agent.Responder
+	if cfg.Proxy.AgentCheckEnabled {
+		agentResponder = agent.NewResponder(cfg, proxyServer, dq, rc)
+		if err := agentResponder.Start(context.Background()); err != nil {
+			log.Fatalf("[main] Failed to start HAProxy agent-check: %v", err)
+		}
+		defer func() {
+			if err := agentResponder.Stop(); err != nil {
+				log.Printf("[main] HAProxy agent-check stop error: %v", err)
+			}
+		}()
+	}
+
 	if err := proxyServer.Start(context.Background()); err != nil {
 		log.Fatalf("[main] Failed to start TDS proxy: %v", err)
 	}
@@ -152,6 +160,19 @@ func main() {
 	}()
 	log.Printf("[main] TDS proxy listening on %s:%d", cfg.Proxy.ListenAddr, cfg.Proxy.ListenPort)
 
+	// ─── SQL Server Browser (opcional) ──────────────────────────────
+	if cfg.Proxy.BrowserEnabled {
+		responder := browser.NewResponder(cfg)
+		if err := responder.Start(context.Background()); err != nil {
+			log.Fatalf("[main] Failed to start SQL Browser responder: %v", err)
+		}
+		defer func() {
+			if err := responder.Stop(); err != nil {
+				log.Printf("[main] SQL Browser responder stop error: %v", err)
+			}
+		}()
+	}
+
 	// ─── Shutdown Gracioso ───────────────────────────────────────────
 	sigCh := make(chan os.Signal, 1)
 	signal.Notify(sigCh, syscall.SIGINT, syscall.SIGTERM)
@@ -160,6 +181,10 @@ func main() {
 	sig := <-sigCh
 	log.Printf("[main] Received signal %v, shutting down gracefully...", sig)
 
+	if agentResponder != nil {
+		agentResponder.Drain()
+	}
+
 	shutdownCtx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
 	defer cancel()
 
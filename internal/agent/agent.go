@@ -0,0 +1,159 @@
+// Package agent implementa o agent-check do HAProxy: um responder TCP que,
+// a cada conexão, devolve uma linha com o estado e o peso desta instância
+// do proxy. Com "agent-check" nas linhas server do haproxy.cfg, o
+// balanceador passa a enviar novas sessões à instância menos carregada em
+// vez de distribuí-las por igual.
+package agent
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"strconv"
+	"sync/atomic"
+	"time"
+
+	"github.com/joao-brasil/poc-connection-pooling/internal/config"
+	"github.com/joao-brasil/poc-connection-pooling/internal/coordinator"
+	"github.com/joao-brasil/poc-connection-pooling/internal/proxy"
+	"github.com/joao-brasil/poc-connection-pooling/internal/queue"
+)
+
+// ── Resposta do agent-check ─────────────────────────────────────────────
+//
+// O HAProxy abre uma conexão TCP, lê uma linha ASCII e fecha. Respostas:
+//
+//	"up 73%\n" — instância disponível, com peso relativo de 73%
+//	"drain\n"  — shutdown em andamento: nenhuma sessão nova, as atuais seguem
+//
+// O peso é a capacidade livre da instância, o produto de três fatores:
+//
+//	sessões    1 - sessões ativas / agent_check_max_sessions
+//	fila       1 - maior (profundidade da fila / max_connections) entre os buckets
+//	fallback   1 / fallback.local_limit_divisor com o Redis indisponível,
+//	           em que a instância só usa sua fração local dos limites
+//
+// O peso nunca fica abaixo de 1%: com todas as instâncias saturadas, o
+// HAProxy ainda consegue distribuir entre elas.
+
+// minWeight é o menor peso anunciado enquanto a instância está disponível.
+const minWeight = 1
+
+// writeTimeout limita o envio da resposta a um HAProxy que não lê.
+const writeTimeout = 2 * time.Second
+
+// Responder responde ao agent-check do HAProxy.
+type Responder struct {
+	cfg         *config.Config
+	server      *proxy.Server
+	dqueue      *queue.DistributedQueue
+	coordinator *coordinator.RedisCoordinator
+
+	listener net.Listener
+	draining atomic.Bool
+}
+
+// NewResponder cria um responder que calcula o peso a partir do servidor
+// TDS, da fila distribuída e do estado do coordenador.
+func NewResponder(cfg *config.Config, server *proxy.Server, dq *queue.DistributedQueue, rc *coordinator.RedisCoordinator) *Responder {
+	return &Responder{
+		cfg:         cfg,
+		server:      server,
+		dqueue:      dq,
+		coordinator: rc,
+	}
+}
+
+// Start começa a escutar na porta do agent-check.
+func (r *Responder) Start(ctx context.Context) error {
+	addr := net.JoinHostPort(r.cfg.Proxy.ListenAddr, strconv.Itoa(r.cfg.Proxy.AgentCheckPort))
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return fmt.Errorf("listen on %s: %w", addr, err)
+	}
+	r.listener = listener
+	log.Printf("[agent] HAProxy agent-check listening on %s", addr)
+
+	go r.serve(ctx)
+	return nil
+}
+
+// Drain passa a responder "drain", para que o HAProxy pare de enviar
+// sessões novas enquanto as atuais terminam.
+func (r *Responder) Drain() {
+	if !r.draining.Swap(true) {
+		log.Printf("[agent] Draining: reporting drain to HAProxy")
+	}
+}
+
+// Stop fecha o listener.
+func (r *Responder) Stop() error {
+	if r.listener == nil {
+		return nil
+	}
+	return r.listener.Close()
+}
+
+// serve aceita as conexões do HAProxy até o listener ser fechado.
+func (r *Responder) serve(ctx context.Context) {
+	for {
+		conn, err := r.listener.Accept()
+		if err != nil {
+			select {
+			case <-ctx.Done():
+				return
+			default:
+			}
+			if errors.Is(err, net.ErrClosed) {
+				return
+			}
+			log.Printf("[agent] Accept error: %v", err)
+			time.Sleep(100 * time.Millisecond)
+			continue
+		}
+
+		_ = conn.SetWriteDeadline(time.Now().Add(writeTimeout))
+		if _, err := conn.Write([]byte(r.Status())); err != nil {
+			log.Printf("[agent] Failed to answer %s: %v", conn.RemoteAddr(), err)
+		}
+		conn.Close()
+	}
+}
+
+// Status monta a linha de resposta do agent-check.
+func (r *Responder) Status() string {
+	if r.draining.Load() {
+		return "drain\n"
+	}
+	return fmt.Sprintf("up %d%%\n", r.weight())
+}
+
+// weight calcula o peso da instância (minWeight a 100).
+func (r *Responder) weight() int {
+	free := 1.0
+
+	if limit := r.cfg.Proxy.AgentCheckMaxSessions; limit > 0 {
+		free *= 1 - min(float64(r.server.ActiveSessions())/float64(limit), 1)
+	}
+
+	var pressure float64
+	for _, b := range r.cfg.Buckets {
+		if b.MaxConnections <= 0 {
+			continue
+		}
+		pressure = max(pressure, float64(r.dqueue.Depth(b.ID))/float64(b.MaxConnections))
+	}
+	free *= 1 - min(pressure, 1)
+
+	if r.coordinator.IsFallback() && r.cfg.Fallback.LocalLimitDivisor > 1 {
+		free /= float64(r.cfg.Fallback.LocalLimitDivisor)
+	}
+
+	w := int(free * 100)
+	if w < minWeight {
+		return minWeight
+	}
+	return w
+}
@@ -0,0 +1,190 @@
+// Package auth valida as credenciais que os clientes enviam no Login7 contra
+// o user store do proxy. Com ele habilitado, o login no backend usa sempre
+// as credenciais de serviço do bucket e as aplicações dos tenants nunca
+// conhecem a senha do RDS.
+package auth
+
+import (
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+
+	"golang.org/x/crypto/bcrypt"
+	"golang.org/x/crypto/scrypt"
+	"gopkg.in/yaml.v3"
+)
+
+// ── User store ──────────────────────────────────────────────────────────
+//
+// Arquivo YAML com um usuário por entrada:
+//
+//	users:
+//	  - username: "app_tenant1"
+//	    password_hash: "$2a$10$..."                    # bcrypt
+//	    buckets: ["bucket-001"]                        # vazio = todos
+//	  - username: "reporting"
+//	    password_hash: "$scrypt$ln=15,r=8,p=1$<salt>$<hash>"
+//
+// Hashes bcrypt são os gerados por htpasswd -B ou bcrypt.GenerateFromPassword.
+// Hashes scrypt usam o formato PHC acima, com N = 2^ln e salt e hash em
+// base64 sem padding. Usernames são comparados sem diferenciar maiúsculas,
+// como os logins do SQL Server.
+
+// ErrInvalidCredentials é retornado para usuário desconhecido ou senha
+// incorreta, sem distinguir os dois casos.
+var ErrInvalidCredentials = errors.New("invalid credentials")
+
+// User é um usuário do proxy.
+type User struct {
+	Username     string   `yaml:"username"`
+	PasswordHash string   `yaml:"password_hash"`
+	Buckets      []string `yaml:"buckets"`
+
+	verify func(password string) bool
+}
+
+// Allows indica se o usuário pode usar o bucket.
+func (u *User) Allows(bucketID string) bool {
+	if len(u.Buckets) == 0 {
+		return true
+	}
+	for _, id := range u.Buckets {
+		if id == bucketID {
+			return true
+		}
+	}
+	return false
+}
+
+// Store é o conjunto de usuários do proxy, indexado por username minúsculo.
+type Store struct {
+	users map[string]*User
+
+	// decoy verifica a senha de usuários desconhecidos com o custo de um
+	// hash real, para que o tempo de resposta não revele quais existem.
+	decoy func(password string) bool
+}
+
+// usersFile espelha a estrutura YAML do arquivo de usuários.
+type usersFile struct {
+	Users []User `yaml:"users"`
+}
+
+// LoadStore lê o arquivo de usuários e valida os hashes.
+func LoadStore(path string) (*Store, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("reading users file %s: %w", path, err)
+	}
+	var file usersFile
+	if err := yaml.Unmarshal(data, &file); err != nil {
+		return nil, fmt.Errorf("parsing users file %s: %w", path, err)
+	}
+
+	s := &Store{users: make(map[string]*User, len(file.Users))}
+	for i := range file.Users {
+		u := &file.Users[i]
+		if u.Username == "" {
+			return nil, fmt.Errorf("users[%d].username is required", i)
+		}
+		key := strings.ToLower(u.Username)
+		if _, dup := s.users[key]; dup {
+			return nil, fmt.Errorf("users[%d]: duplicate username %q", i, u.Username)
+		}
+		u.verify, err = parseHash(u.PasswordHash)
+		if err != nil {
+			return nil, fmt.Errorf("users[%d] (%s): %w", i, u.Username, err)
+		}
+		s.users[key] = u
+		if s.decoy == nil {
+			s.decoy = u.verify
+		}
+	}
+	return s, nil
+}
+
+// Len retorna o número de usuários.
+func (s *Store) Len() int {
+	return len(s.users)
+}
+
+// Authenticate valida username e senha e retorna o usuário.
+func (s *Store) Authenticate(username, password string) (*User, error) {
+	u, ok := s.users[strings.ToLower(username)]
+	if !ok {
+		if s.decoy != nil {
+			s.decoy(password)
+		}
+		return nil, ErrInvalidCredentials
+	}
+	if !u.verify(password) {
+		return nil, ErrInvalidCredentials
+	}
+	return u, nil
+}
+
+// parseHash reconhece o algoritmo do hash e retorna a função que o verifica.
+func parseHash(hash string) (func(string) bool, error) {
+	switch {
+	case strings.HasPrefix(hash, "$2a$"), strings.HasPrefix(hash, "$2b$"), strings.HasPrefix(hash, "$2y$"):
+		if _, err := bcrypt.Cost([]byte(hash)); err != nil {
+			return nil, fmt.Errorf("invalid bcrypt hash: %w", err)
+		}
+		return func(password string) bool {
+			return bcrypt.CompareHashAndPassword([]byte(hash), []byte(password)) == nil
+		}, nil
+	case strings.HasPrefix(hash, "$scrypt$"):
+		return parseScrypt(hash)
+	case hash == "":
+		return nil, fmt.Errorf("password_hash is required")
+	}
+	return nil, fmt.Errorf("unsupported password_hash (expected bcrypt or $scrypt$)")
+}
+
+// parseScrypt lê um hash "$scrypt$ln=<log2 N>,r=<r>,p=<p>$<salt>$<hash>".
+func parseScrypt(hash string) (func(string) bool, error) {
+	parts := strings.Split(hash, "$")
+	if len(parts) != 5 {
+		return nil, fmt.Errorf("invalid scrypt hash: expected $scrypt$<params>$<salt>$<hash>")
+	}
+
+	var ln, r, p int
+	for _, kv := range strings.Split(parts[2], ",") {
+		name, value, _ := strings.Cut(kv, "=")
+		n, err := strconv.Atoi(value)
+		if err != nil {
+			return nil, fmt.Errorf("invalid scrypt parameter %q", kv)
+		}
+		switch name {
+		case "ln":
+			ln = n
+		case "r":
+			r = n
+		case "p":
+			p = n
+		default:
+			return nil, fmt.Errorf("unknown scrypt parameter %q", name)
+		}
+	}
+	if ln < 1 || ln > 30 || r < 1 || p < 1 {
+		return nil, fmt.Errorf("invalid scrypt parameters %q", parts[2])
+	}
+
+	salt, err := base64.RawStdEncoding.DecodeString(parts[3])
+	if err != nil {
+		return nil, fmt.Errorf("invalid scrypt salt: %w", err)
+	}
+	key, err := base64.RawStdEncoding.DecodeString(parts[4])
+	if err != nil || len(key) == 0 {
+		return nil, fmt.Errorf("invalid scrypt hash value")
+	}
+
+	return func(password string) bool {
+		got, err := scrypt.Key([]byte(password), salt, 1<<ln, r, p, len(key))
+		return err == nil && subtle.ConstantTimeCompare(got, key) == 1
+	}, nil
+}
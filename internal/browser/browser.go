@@ -0,0 +1,169 @@
+// Package browser emula o serviço SQL Server Browser (MC-SQLR, UDP 1434).
+// Clientes que se conectam a host\instância perguntam ao Browser a porta TCP
+// da instância antes de abrir a conexão; o proxy responde anunciando cada
+// bucket configurado como uma instância nomeada.
+package browser
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/joao-brasil/poc-connection-pooling/internal/config"
+	"github.com/joao-brasil/poc-connection-pooling/pkg/bucket"
+)
+
+// ── Protocolo SQL Server Resolution (MC-SQLR) ───────────────────────────
+//
+// Requisições (primeiro byte do datagrama):
+//
+//	CLNT_BCAST_EX   0x02              — lista todas as instâncias (broadcast)
+//	CLNT_UCAST_EX   0x03              — lista todas as instâncias (unicast)
+//	CLNT_UCAST_INST 0x04 + nome + \0  — informações de uma instância
+//
+// Resposta SVR_RESP: 0x05, RESP_SIZE (USHORT LE) e RESP_DATA, uma string
+// com um registro por instância:
+//
+//	ServerName;HOST;InstanceName;BUCKET;IsClustered;No;Version;16.0.4236.0;tcp;1433;;
+//
+// Instâncias desconhecidas não recebem resposta, como no Browser real.
+
+// Tipos de mensagem MC-SQLR.
+const (
+	clntBcastEx   byte = 0x02
+	clntUcastEx   byte = 0x03
+	clntUcastInst byte = 0x04
+	svrResp       byte = 0x05
+)
+
+// maxListResp é o limite de RESP_DATA de uma listagem de instâncias.
+const maxListResp = 65535
+
+// serverVersion é a versão anunciada, a mesma da resposta Pre-Login do proxy.
+const serverVersion = "16.0.4236.0"
+
+// Responder responde às requisições MC-SQLR.
+type Responder struct {
+	cfg        *config.Config
+	serverName string
+	conn       net.PacketConn
+}
+
+// NewResponder cria um responder para os buckets da configuração.
+func NewResponder(cfg *config.Config) *Responder {
+	hostname, _ := os.Hostname()
+	return &Responder{
+		cfg:        cfg,
+		serverName: strings.ToUpper(hostname),
+	}
+}
+
+// Start começa a escutar no endereço UDP do Browser.
+func (r *Responder) Start(ctx context.Context) error {
+	addr := net.JoinHostPort(r.cfg.Proxy.ListenAddr, strconv.Itoa(r.cfg.Proxy.BrowserPort))
+	conn, err := net.ListenPacket("udp", addr)
+	if err != nil {
+		return fmt.Errorf("listen on udp %s: %w", addr, err)
+	}
+	r.conn = conn
+	log.Printf("[browser] SQL Browser responder listening on udp %s (%d instances)", addr, len(r.cfg.Buckets))
+
+	go r.serve(ctx)
+	return nil
+}
+
+// Stop fecha o socket UDP.
+func (r *Responder) Stop() error {
+	if r.conn == nil {
+		return nil
+	}
+	return r.conn.Close()
+}
+
+// serve lê e responde datagramas até o socket ser fechado.
+func (r *Responder) serve(ctx context.Context) {
+	buf := make([]byte, 1024)
+	for {
+		n, peer, err := r.conn.ReadFrom(buf)
+		if err != nil {
+			select {
+			case <-ctx.Done():
+				return
+			default:
+			}
+			if errors.Is(err, net.ErrClosed) {
+				return
+			}
+			log.Printf("[browser] Read error: %v", err)
+			continue
+		}
+
+		resp := r.handle(buf[:n])
+		if resp == nil {
+			continue
+		}
+		if _, err := r.conn.WriteTo(resp, peer); err != nil {
+			log.Printf("[browser] Failed to answer %s: %v", peer, err)
+		}
+	}
+}
+
+// handle monta a resposta de uma requisição, ou nil se não houver resposta.
+func (r *Responder) handle(req []byte) []byte {
+	if len(req) == 0 {
+		return nil
+	}
+	switch req[0] {
+	case clntBcastEx, clntUcastEx:
+		var data []byte
+		for i := range r.cfg.Buckets {
+			record := r.instanceRecord(&r.cfg.Buckets[i])
+			if len(data)+len(record) > maxListResp {
+				break
+			}
+			data = append(data, record...)
+		}
+		return svrResponse(data)
+
+	case clntUcastInst:
+		name := req[1:]
+		if i := bytes.IndexByte(name, 0); i >= 0 {
+			name = name[:i]
+		}
+		// Nomes de instância não diferenciam maiúsculas.
+		for i := range r.cfg.Buckets {
+			if strings.EqualFold(r.cfg.Buckets[i].ID, string(name)) {
+				return svrResponse(r.instanceRecord(&r.cfg.Buckets[i]))
+			}
+		}
+		log.Printf("[browser] Unknown instance %q requested", name)
+	}
+	return nil
+}
+
+// instanceRecord monta o registro RESP_DATA da instância de um bucket. A
+// porta é a listen_port do bucket, se houver, ou a porta principal do proxy
+// (que roteia pelo nome da instância no Pre-Login).
+func (r *Responder) instanceRecord(b *bucket.Bucket) []byte {
+	port := r.cfg.Proxy.ListenPort
+	if b.ListenPort != 0 {
+		port = b.ListenPort
+	}
+	return []byte(fmt.Sprintf("ServerName;%s;InstanceName;%s;IsClustered;No;Version;%s;tcp;%d;;",
+		r.serverName, b.ID, serverVersion, port))
+}
+
+// svrResponse encapsula RESP_DATA em uma mensagem SVR_RESP.
+func svrResponse(data []byte) []byte {
+	buf := make([]byte, 3, 3+len(data))
+	buf[0] = svrResp
+	binary.LittleEndian.PutUint16(buf[1:3], uint16(len(data)))
+	return append(buf, data...)
+}
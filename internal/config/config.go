@@ -3,6 +3,8 @@ package config
 
 import (
 	"fmt"
+	"net"
+	"net/netip"
 	"os"
 	"time"
 
@@ -15,14 +17,66 @@ type ProxyConfig struct {
 	ListenAddr          string        `yaml:"listen_addr"`
 	ListenPort          int           `yaml:"listen_port"`
 	InstanceID          string        `yaml:"instance_id"`
-	SessionTimeout      time.Duration `yaml:"session_timeout"`
 	IdleTimeout         time.Duration `yaml:"idle_timeout"`
 	QueueTimeout        time.Duration `yaml:"queue_timeout"`
+	AttentionTimeout    time.Duration `yaml:"attention_timeout"`
 	MaxQueueSize        int           `yaml:"max_queue_size"`
 	PinningMode         string        `yaml:"pinning_mode"`
 	HealthCheckInterval time.Duration `yaml:"health_check_interval"`
 	HealthCheckPort     int           `yaml:"health_check_port"`
 	MetricsPort         int           `yaml:"metrics_port"`
+
+	// IdleInTransactionTimeout substitui IdleTimeout enquanto a sessão está
+	// pinada por uma transação aberta, que segura locks e a conexão backend.
+	IdleInTransactionTimeout time.Duration `yaml:"idle_in_transaction_timeout"`
+
+	// Emulação do SQL Server Browser (UDP), que anuncia cada bucket como
+	// instância nomeada para connection strings host\instância.
+	BrowserEnabled bool `yaml:"browser_enabled"`
+	BrowserPort    int  `yaml:"browser_port"`
+
+	// PROXY protocol (v1/v2) do balanceador à frente do proxy. Conexões
+	// vindas de ProxyProtocolTrustedCIDRs precisam trazer o cabeçalho com o
+	// endereço real do cliente; as demais são tratadas como clientes diretos.
+	ProxyProtocol             bool     `yaml:"proxy_protocol"`
+	ProxyProtocolTrustedCIDRs []string `yaml:"proxy_protocol_trusted_cidrs"`
+
+	// Agent-check do HAProxy (TCP), que anuncia o peso da instância conforme
+	// sessões ativas, filas e modo fallback, e "drain" durante o shutdown.
+	AgentCheckEnabled     bool `yaml:"agent_check_enabled"`
+	AgentCheckPort        int  `yaml:"agent_check_port"`
+	AgentCheckMaxSessions int  `yaml:"agent_check_max_sessions"`
+
+	// AuthUsersFile habilita a autenticação no proxy: o Login7 do cliente é
+	// validado contra este arquivo de usuários (hashes bcrypt ou scrypt) e o
+	// backend recebe as credenciais de serviço do bucket.
+	AuthUsersFile string `yaml:"auth_users_file"`
+
+	// TransientErrors define os números de erro das rejeições por falta de
+	// capacidade, que drivers repetem automaticamente.
+	TransientErrors TransientErrorsConfig `yaml:"transient_errors"`
+
+	// Terminação TLS no Pre-Login. Sem certificado, o proxy responde
+	// ENCRYPT_NOT_SUP e clientes que exigem criptografia não conectam.
+	TLSCertFile string `yaml:"tls_cert_file"`
+	TLSKeyFile  string `yaml:"tls_key_file"`
+
+	// TLS com os backends. O certificado do servidor é validado contra as
+	// CAs do sistema ou, se informado, contra o bundle PEM de
+	// BackendTLSCAFile (ex: o bundle de CAs do RDS). BackendTLSSkipVerify
+	// desliga a validação e só serve para containers com certificado
+	// autoassinado.
+	BackendTLSCAFile     string `yaml:"backend_tls_ca_file"`
+	BackendTLSSkipVerify bool   `yaml:"backend_tls_skip_verify"`
+}
+
+// TransientErrorsConfig mapeia cada classe de rejeição a um número de erro
+// (padrão: os transitórios do SQL Server; os números próprios do proxy,
+// 50003-50005, desligam o retry automático dos drivers).
+type TransientErrorsConfig struct {
+	QueueFull          uint32 `yaml:"queue_full"`
+	QueueTimeout       uint32 `yaml:"queue_timeout"`
+	BackendUnavailable uint32 `yaml:"backend_unavailable"`
 }
 
 // RedisConfig contém a configuração de conexão do Redis.
@@ -107,9 +161,24 @@ func (c *Config) validate() error {
 	if c.Proxy.ListenPort == 0 {
 		return fmt.Errorf("proxy.listen_port is required")
 	}
+	if !validPinningMode(c.Proxy.PinningMode) {
+		return fmt.Errorf("proxy.pinning_mode %q is invalid (session | transaction | statement)", c.Proxy.PinningMode)
+	}
+	if (c.Proxy.TLSCertFile == "") != (c.Proxy.TLSKeyFile == "") {
+		return fmt.Errorf("proxy.tls_cert_file and proxy.tls_key_file must be set together")
+	}
+	if c.Proxy.ProxyProtocol && len(c.Proxy.ProxyProtocolTrustedCIDRs) == 0 {
+		return fmt.Errorf("proxy.proxy_protocol requires proxy.proxy_protocol_trusted_cidrs")
+	}
+	for _, cidr := range c.Proxy.ProxyProtocolTrustedCIDRs {
+		if _, err := netip.ParsePrefix(cidr); err != nil {
+			return fmt.Errorf("proxy.proxy_protocol_trusted_cidrs: %w", err)
+		}
+	}
 	if len(c.Buckets) == 0 {
 		return fmt.Errorf("at least one bucket must be configured")
 	}
+	listenPorts := map[int]string{c.Proxy.ListenPort: "proxy.listen_port"}
 	for i, b := range c.Buckets {
 		if b.ID == "" {
 			return fmt.Errorf("bucket[%d].id is required", i)
@@ -123,6 +192,35 @@ func (c *Config) validate() error {
 		if b.MaxConnections == 0 {
 			return fmt.Errorf("bucket[%d].max_connections is required", i)
 		}
+		if !validPinningMode(b.PinningMode) {
+			return fmt.Errorf("bucket[%d].pinning_mode %q is invalid (session | transaction | statement)", i, b.PinningMode)
+		}
+		for _, ep := range b.Endpoints {
+			if _, _, err := net.SplitHostPort(ep); err != nil {
+				return fmt.Errorf("bucket[%d].endpoints: %q is not host:port", i, ep)
+			}
+		}
+		if b.DialRetries < 0 {
+			return fmt.Errorf("bucket[%d].dial_retries must not be negative", i)
+		}
+		if b.DNSRefreshInterval < 0 {
+			return fmt.Errorf("bucket[%d].dns_refresh_interval must not be negative", i)
+		}
+		if b.ListenPort != 0 {
+			if other, ok := listenPorts[b.ListenPort]; ok {
+				return fmt.Errorf("bucket[%d].listen_port %d is already used by %s", i, b.ListenPort, other)
+			}
+			listenPorts[b.ListenPort] = fmt.Sprintf("bucket %s", b.ID)
+		}
+		if b.IsReplica() {
+			primary, ok := c.BucketByID(b.ReplicaOf)
+			if !ok {
+				return fmt.Errorf("bucket[%d].replica_of %q does not match any bucket", i, b.ReplicaOf)
+			}
+			if primary.IsReplica() {
+				return fmt.Errorf("bucket[%d].replica_of %q is itself a replica", i, b.ReplicaOf)
+			}
+		}
 	}
 	return nil
 }
@@ -132,20 +230,23 @@ func (c *Config) applyDefaults() {
 	if c.Proxy.ListenAddr == "" {
 		c.Proxy.ListenAddr = "0.0.0.0"
 	}
-	if c.Proxy.SessionTimeout == 0 {
-		c.Proxy.SessionTimeout = 5 * time.Minute
-	}
 	if c.Proxy.IdleTimeout == 0 {
 		c.Proxy.IdleTimeout = 60 * time.Second
 	}
+	if c.Proxy.IdleInTransactionTimeout == 0 {
+		c.Proxy.IdleInTransactionTimeout = 30 * time.Second
+	}
 	if c.Proxy.QueueTimeout == 0 {
 		c.Proxy.QueueTimeout = 30 * time.Second
 	}
+	if c.Proxy.AttentionTimeout == 0 {
+		c.Proxy.AttentionTimeout = 5 * time.Second
+	}
 	if c.Proxy.MaxQueueSize == 0 {
 		c.Proxy.MaxQueueSize = 1000
 	}
 	if c.Proxy.PinningMode == "" {
-		c.Proxy.PinningMode = "transaction"
+		c.Proxy.PinningMode = bucket.PinningTransaction
 	}
 	if c.Proxy.HealthCheckInterval == 0 {
 		c.Proxy.HealthCheckInterval = 15 * time.Second
@@ -156,6 +257,24 @@ func (c *Config) applyDefaults() {
 	if c.Proxy.MetricsPort == 0 {
 		c.Proxy.MetricsPort = 9090
 	}
+	if c.Proxy.BrowserPort == 0 {
+		c.Proxy.BrowserPort = 1434
+	}
+	if c.Proxy.AgentCheckPort == 0 {
+		c.Proxy.AgentCheckPort = 8081
+	}
+	if c.Proxy.AgentCheckMaxSessions == 0 {
+		c.Proxy.AgentCheckMaxSessions = 1000
+	}
+	if c.Proxy.TransientErrors.QueueFull == 0 {
+		c.Proxy.TransientErrors.QueueFull = 40501
+	}
+	if c.Proxy.TransientErrors.QueueTimeout == 0 {
+		c.Proxy.TransientErrors.QueueTimeout = 49918
+	}
+	if c.Proxy.TransientErrors.BackendUnavailable == 0 {
+		c.Proxy.TransientErrors.BackendUnavailable = 40613
+	}
 	if c.Proxy.InstanceID == "" {
 		hostname, _ := os.Hostname()
 		c.Proxy.InstanceID = hostname
@@ -186,9 +305,6 @@ func (c *Config) applyDefaults() {
 	}
 
 	for i := range c.Buckets {
-		if c.Buckets[i].MinIdle == 0 {
-			c.Buckets[i].MinIdle = 2
-		}
 		if c.Buckets[i].MaxIdleTime == 0 {
 			c.Buckets[i].MaxIdleTime = 5 * time.Minute
 		}
@@ -198,7 +314,41 @@ func (c *Config) applyDefaults() {
 		if c.Buckets[i].QueueTimeout == 0 {
 			c.Buckets[i].QueueTimeout = c.Proxy.QueueTimeout
 		}
+		if c.Buckets[i].DialBackoff == 0 {
+			c.Buckets[i].DialBackoff = 250 * time.Millisecond
+		}
+		if c.Buckets[i].DNSRefreshInterval == 0 {
+			c.Buckets[i].DNSRefreshInterval = 5 * time.Second
+		}
+		if c.Buckets[i].PinningMode == "" {
+			c.Buckets[i].PinningMode = c.Proxy.PinningMode
+		}
+		if c.Buckets[i].IsReplica() {
+			c.inheritFromPrimary(&c.Buckets[i])
+		}
+	}
+}
+
+// inheritFromPrimary completa uma réplica com database e credenciais do
+// bucket primário.
+func (c *Config) inheritFromPrimary(replica *bucket.Bucket) {
+	primary, _ := c.BucketByID(replica.ReplicaOf)
+	if replica.Database == "" {
+		replica.Database = primary.Database
+	}
+	if replica.Username == "" {
+		replica.Username = primary.Username
+		replica.Password = primary.Password
+	}
+}
+
+// validPinningMode verifica um valor de pinning_mode (vazio = padrão).
+func validPinningMode(mode string) bool {
+	switch mode {
+	case "", bucket.PinningSession, bucket.PinningTransaction, bucket.PinningStatement:
+		return true
 	}
+	return false
 }
 
 // BucketByID retorna a configuração do bucket para um dado ID de bucket.
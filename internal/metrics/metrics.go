@@ -65,6 +65,48 @@ var (
 		Buckets: []float64{0.001, 0.005, 0.01, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10},
 	}, []string{"bucket_id"})
 
+	// QueryTimeouts conta requisições canceladas pelo query_timeout do bucket.
+	QueryTimeouts = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "proxy_query_timeouts_total",
+		Help: "Total requests cancelled by the bucket query timeout",
+	}, []string{"bucket_id"})
+
+	// BackendRows soma o RowCount dos DONE* com DONE_COUNT: linhas
+	// retornadas ou afetadas pelos comandos executados no backend.
+	BackendRows = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "proxy_backend_rows_total",
+		Help: "Total rows returned or affected by backend statements",
+	}, []string{"bucket_id"})
+
+	// BackendErrors conta os tokens ERROR enviados pelo backend, por número
+	// do erro (ex: 1205 deadlock, 3906 database read-only).
+	BackendErrors = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "proxy_backend_errors_total",
+		Help: "Total errors returned by the backend per error number",
+	}, []string{"bucket_id", "error_number"})
+
+	// ReplicaHealthy indica se uma réplica de leitura recebe sessões
+	// read-only (1) ou está fora de rotação (0).
+	ReplicaHealthy = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "proxy_replica_healthy",
+		Help: "Read replica health as seen by the router (1 = in rotation, 0 = down)",
+	}, []string{"bucket_id"})
+
+	// Failovers conta failovers de backend detectados por bucket e motivo
+	// (dns_change, read_only), após os quais os pools do bucket são drenados.
+	Failovers = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "proxy_failovers_total",
+		Help: "Total backend failovers detected per bucket",
+	}, []string{"bucket_id", "reason"})
+
+	// ClientConnections conta conexões de cliente pela origem do endereço:
+	// "proxy_protocol" (endereço real informado pelo balanceador) ou "direct".
+	// O IP do cliente vai só para os logs, para não criar uma série por IP.
+	ClientConnections = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "proxy_client_connections_total",
+		Help: "Total client connections accepted per address source",
+	}, []string{"source"})
+
 	// ConnectionErrors conta erros de conexão por tipo.
 	ConnectionErrors = promauto.NewCounterVec(prometheus.CounterOpts{
 		Name: "proxy_connection_errors_total",
@@ -0,0 +1,404 @@
+package proxy
+
+import (
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/joao-brasil/poc-connection-pooling/internal/metrics"
+	"github.com/joao-brasil/poc-connection-pooling/internal/tds"
+)
+
+// ── Cancelamento (ATTENTION) ────────────────────────────────────────────
+//
+// Um ATTENTION do cliente pode chegar a qualquer momento, inclusive
+// enquanto a goroutine principal está bloqueada lendo a resposta do
+// backend. Nos modos transaction e statement as mensagens do cliente são
+// lidas por uma goroutine própria (readClient), que trata o ATTENTION
+// conforme o estado da requisição corrente:
+//
+//   - Nenhuma requisição em andamento: o proxy confirma com DONE_ATTN.
+//   - Requisição lida mas ainda não enviada ao backend: ela é descartada e
+//     o proxy confirma com DONE_ATTN.
+//   - Requisição em execução no backend: o ATTENTION é encaminhado e a
+//     resposta continua sendo retransmitida até o DONE_ATTN do servidor.
+//
+// A conexão backend só volta a ser reutilizável depois do DONE_ATTN. Se ele
+// não chegar dentro de attention_timeout, a conexão é descartada.
+//
+// O query_timeout do bucket usa o mesmo mecanismo: o proxy envia o ATTENTION
+// por conta própria, descarta o restante da resposta e, após o DONE_ATTN,
+// encerra a resposta do cliente com um erro TDS no lugar da confirmação.
+
+// clientMessage é uma mensagem lida do cliente por readClient.
+type clientMessage struct {
+	pktType tds.PacketType
+	payload []byte
+	packets [][]byte
+	err     error
+}
+
+// readClient lê as mensagens do cliente durante a fase de dados pooled.
+// ATTENTION é tratado aqui; as demais mensagens seguem para msgs e marcam
+// uma requisição em andamento até finishRequest.
+func (s *Session) readClient(msgs chan<- clientMessage, done <-chan struct{}) {
+	for {
+		// Entre requisições vale o prazo de ociosidade; durante uma
+		// requisição a leitura só aguarda um eventual ATTENTION.
+		s.reqMu.Lock()
+		if s.reqActive {
+			s.clearIdleDeadline()
+		} else {
+			s.armIdleDeadline()
+		}
+		s.reqMu.Unlock()
+
+		pktType, payload, packets, err := tds.ReadMessage(s.clientConn)
+		if err == nil && tds.IsAttention(pktType) {
+			s.countPacket(tds.DirectionClientToServer, pktType)
+			s.cancelRequest()
+			continue
+		}
+		if err == nil {
+			s.reqMu.Lock()
+			s.reqActive = true
+			s.reqMu.Unlock()
+		}
+
+		select {
+		case msgs <- clientMessage{pktType: pktType, payload: payload, packets: packets, err: err}:
+		case <-done:
+			return
+		}
+		if err != nil {
+			return
+		}
+	}
+}
+
+// cancelRequest trata um ATTENTION do cliente no modo pooled.
+func (s *Session) cancelRequest() {
+	s.reqMu.Lock()
+	defer s.reqMu.Unlock()
+
+	switch {
+	case s.inFlight != nil:
+		if s.attnSent {
+			return
+		}
+		log.Printf("[session:%d] Forwarding attention to backend", s.id)
+		s.sendAttention()
+
+	case s.reqActive:
+		s.cancelled = true
+
+	default:
+		// A resposta já foi entregue: não há o que cancelar, mas o cliente
+		// aguarda a confirmação.
+		if err := tds.WritePackets(s.clientConn, [][]byte{tds.BuildAttentionAck()}); err != nil {
+			log.Printf("[session:%d] Failed to acknowledge attention: %v", s.id, err)
+		}
+	}
+}
+
+// sendAttention envia um ATTENTION ao backend em execução e limita a espera
+// pelo DONE_ATTN. Chamado com reqMu.
+func (s *Session) sendAttention() {
+	s.attnSent = true
+	if err := tds.WritePackets(s.inFlight.conn, [][]byte{tds.BuildAttention()}); err != nil {
+		// A leitura da resposta vai falhar e a conexão será descartada.
+		log.Printf("[session:%d] Failed to send attention: %v", s.id, err)
+	}
+	s.inFlight.conn.SetReadDeadline(time.Now().Add(s.cfg.Proxy.AttentionTimeout))
+}
+
+// queryTimeout é disparado quando a requisição em execução em b excede o
+// query_timeout do bucket.
+func (s *Session) queryTimeout(b *backend) {
+	s.reqMu.Lock()
+	defer s.reqMu.Unlock()
+
+	if s.inFlight != b || s.attnSent {
+		// Já terminou, ou o cliente cancelou antes.
+		return
+	}
+	log.Printf("[session:%d] Request exceeded query timeout (%v), cancelling on backend", s.id, s.target.QueryTimeout)
+	metrics.QueryTimeouts.WithLabelValues(s.bucketID).Inc()
+	s.timedOut = true
+	s.sendAttention()
+}
+
+// queryTimedOut indica que a requisição corrente foi cancelada pelo
+// query_timeout; a partir daí a resposta do backend não é mais entregue.
+func (s *Session) queryTimedOut() bool {
+	s.reqMu.Lock()
+	defer s.reqMu.Unlock()
+	return s.timedOut
+}
+
+// stopQueryTimer cancela o query_timeout da requisição. Chamado com reqMu.
+func (s *Session) stopQueryTimer() {
+	if s.queryTimer != nil {
+		s.queryTimer.Stop()
+		s.queryTimer = nil
+	}
+}
+
+// replyCancelled entrega ao cliente o desfecho de um cancelamento concluído:
+// a confirmação (DONE_ATTN) se foi o cliente que cancelou, ou o erro de
+// timeout se foi o query_timeout.
+//
+// No timeout, o erro só pode ser anexado se o cliente ainda aguarda a
+// resposta e o que já recebeu termina em uma fronteira de token; se a
+// resposta foi cortada no meio de um token, a sessão é encerrada.
+func (s *Session) replyCancelled() error {
+	s.reqMu.Lock()
+	timedOut := s.timedOut
+	s.timedOut = false
+	eomSent, midToken := s.respEOMSent, s.respRelayed && !s.respBoundary
+	s.reqMu.Unlock()
+
+	if !timedOut {
+		return tds.WritePackets(s.clientConn, [][]byte{tds.BuildAttentionAck()})
+	}
+	switch {
+	case eomSent:
+		// A resposta completa chegou ao cliente antes do cancelamento.
+		return nil
+	case midToken:
+		return fmt.Errorf("query timeout interrupted the response mid-token")
+	}
+	_, err := s.clientConn.Write(tds.ErrQueryTimeout(s.bucketID, s.target.QueryTimeout))
+	return err
+}
+
+// resetResponseProgress zera o progresso da resposta entregue ao cliente
+// no início de uma requisição. Chamado com reqMu.
+func (s *Session) resetResponseProgress() {
+	s.respRelayed, s.respBoundary, s.respEOMSent = false, true, false
+}
+
+// responseDelivered registra um pacote de resposta entregue ao cliente;
+// boundary indica que ele terminou em uma fronteira de token. Chamado com
+// reqMu.
+func (s *Session) responseDelivered(eom, boundary bool) {
+	s.respRelayed = true
+	s.respBoundary = boundary
+	s.respEOMSent = s.respEOMSent || eom
+}
+
+// requestCancelled indica que o cliente cancelou a requisição corrente antes
+// de ela ser enviada ao backend.
+func (s *Session) requestCancelled() bool {
+	s.reqMu.Lock()
+	defer s.reqMu.Unlock()
+	return s.cancelled
+}
+
+// sendRequest envia a requisição ao backend da sessão e a marca como em
+// execução, de modo que um ATTENTION passe a ser encaminhado a ele.
+func (s *Session) sendRequest(packets [][]byte) error {
+	s.reqMu.Lock()
+	defer s.reqMu.Unlock()
+
+	if err := tds.WritePackets(s.backend.conn, packets); err != nil {
+		return err
+	}
+	s.inFlight = s.backend
+	if timeout := s.target.QueryTimeout; timeout > 0 {
+		b := s.backend
+		s.queryTimer = time.AfterFunc(timeout, func() { s.queryTimeout(b) })
+	}
+	return nil
+}
+
+// responseComplete é chamado a cada fim de mensagem (EOM) da resposta.
+// Sem ATTENTION pendente a requisição terminou; com ATTENTION, só termina
+// quando a resposta trouxe o DONE_ATTN (acked). Uma resposta concluída antes
+// de o servidor processar o ATTENTION é seguida de outra com a confirmação.
+func (s *Session) responseComplete(acked bool) bool {
+	s.reqMu.Lock()
+	defer s.reqMu.Unlock()
+
+	if s.attnSent {
+		if !acked {
+			return false
+		}
+		s.backend.conn.SetReadDeadline(time.Time{})
+		s.attnSent = false
+	}
+	s.stopQueryTimer()
+	s.inFlight = nil
+	return true
+}
+
+// attentionSent indica que um ATTENTION foi encaminhado e ainda não foi
+// confirmado pelo servidor.
+func (s *Session) attentionSent() bool {
+	s.reqMu.Lock()
+	defer s.reqMu.Unlock()
+	return s.attnSent
+}
+
+// attentionFailed trata uma falha de leitura enquanto um ATTENTION aguardava
+// confirmação: a conexão fica em estado desconhecido e é descartada, e o
+// cliente recebe do próprio proxy o desfecho do cancelamento. Se a sessão estava pinada,
+// o estado dela (transação, prepared statements) se perdeu com a conexão e
+// a sessão é encerrada.
+func (s *Session) attentionFailed(err error) error {
+	log.Printf("[session:%d] Backend did not acknowledge attention, discarding connection: %v", s.id, err)
+	metrics.ConnectionErrors.WithLabelValues(s.bucketID, "attention_timeout").Inc()
+
+	s.reqMu.Lock()
+	s.stopQueryTimer()
+	s.inFlight = nil
+	s.attnSent = false
+	s.reqMu.Unlock()
+
+	s.backend.close()
+	s.backend = nil
+	s.respParser.Reset()
+	s.respSkip = false
+
+	if s.isPinned() {
+		return fmt.Errorf("session state lost with discarded backend")
+	}
+	return s.replyCancelled()
+}
+
+// finishRequest encerra a requisição corrente. Um ATTENTION que chegou
+// depois de a resposta ser entregue (ou antes de a requisição ser enviada)
+// é confirmado aqui.
+func (s *Session) finishRequest() error {
+	s.reqMu.Lock()
+	defer s.reqMu.Unlock()
+
+	s.reqActive = false
+	s.stopQueryTimer()
+	s.inFlight = nil
+	s.timedOut = false
+	s.armIdleDeadline()
+	if !s.cancelled {
+		return nil
+	}
+	s.cancelled = false
+	return tds.WritePackets(s.clientConn, [][]byte{tds.BuildAttentionAck()})
+}
+
+// ── Modo session ─────────────────────────────────────────────────────────
+//
+// No modo session os pacotes fluem pelo relay sem que o proxy controle cada
+// requisição, mas o query_timeout segue a mesma lógica: o prazo começa no
+// fim da mensagem do cliente, o ATTENTION é enviado pelo proxy, o restante
+// da resposta (inclusive o DONE_ATTN) é descartado e o cliente recebe o
+// erro de timeout.
+
+// trackAttention registra um ATTENTION retransmitido no modo session. Se o
+// servidor não confirmar a tempo, a conexão backend é fechada, o que encerra
+// o relay e a sessão. Retorna false se já há um ATTENTION pendente (do
+// cliente ou do query_timeout) e este não deve ser encaminhado.
+func (s *Session) trackAttention() bool {
+	s.reqMu.Lock()
+	defer s.reqMu.Unlock()
+
+	if s.attnSent {
+		return false
+	}
+	s.attnSent = true
+	s.startAttentionTimer(s.backend)
+	return true
+}
+
+// startAttentionTimer limita a espera pelo DONE_ATTN no modo session.
+// Chamado com reqMu.
+func (s *Session) startAttentionTimer(b *backend) {
+	s.attnTimer = time.AfterFunc(s.cfg.Proxy.AttentionTimeout, func() {
+		log.Printf("[session:%d] Backend did not acknowledge attention within %v, closing", s.id, s.cfg.Proxy.AttentionTimeout)
+		metrics.ConnectionErrors.WithLabelValues(s.bucketID, "attention_timeout").Inc()
+		b.conn.Close()
+	})
+}
+
+// attentionAcked registra o DONE_ATTN de um ATTENTION retransmitido no modo
+// session. Chamado com reqMu.
+func (s *Session) attentionAcked() {
+	if s.attnTimer != nil {
+		s.attnTimer.Stop()
+		s.attnTimer = nil
+	}
+	s.attnSent = false
+}
+
+// startQueryTimer marca o início de uma requisição no modo session (fim da
+// mensagem do cliente) e arma o query_timeout do bucket.
+func (s *Session) startQueryTimer() {
+	s.reqMu.Lock()
+	defer s.reqMu.Unlock()
+
+	s.resetResponseProgress()
+	timeout := s.target.QueryTimeout
+	if timeout <= 0 {
+		return
+	}
+	s.stopQueryTimer()
+	b := s.backend
+	s.inFlight = b
+	s.queryTimer = time.AfterFunc(timeout, func() { s.sessionQueryTimeout(b) })
+}
+
+// sessionQueryTimeout é disparado quando a requisição do modo session em b
+// excede o query_timeout: o proxy envia o ATTENTION por conta própria.
+func (s *Session) sessionQueryTimeout(b *backend) {
+	s.reqMu.Lock()
+	defer s.reqMu.Unlock()
+
+	if s.inFlight != b || s.attnSent {
+		// Já terminou, ou o cliente cancelou antes.
+		return
+	}
+	log.Printf("[session:%d] Request exceeded query timeout (%v), cancelling on backend", s.id, s.target.QueryTimeout)
+	metrics.QueryTimeouts.WithLabelValues(s.bucketID).Inc()
+	s.timedOut = true
+	s.attnSent = true
+	if err := tds.WritePackets(b.conn, [][]byte{tds.BuildAttention()}); err != nil {
+		// A leitura da resposta vai falhar e o relay termina.
+		log.Printf("[session:%d] Failed to send attention: %v", s.id, err)
+	}
+	s.startAttentionTimer(b)
+}
+
+// sessionResponse acompanha um pacote de resposta do modo session já
+// inspecionado (acked indica um DONE_ATTN). Depois de um query_timeout os
+// pacotes são descartados (tds.ErrSkipPacket) e, no DONE_ATTN, o cliente
+// recebe o erro de timeout (replyCancelled).
+//
+// O pacote é registrado como entregue na mesma seção crítica em que o
+// timeout é verificado, para que replyCancelled veja um progresso coerente
+// mesmo que o query_timeout dispare logo em seguida.
+func (s *Session) sessionResponse(hdr *tds.Header, acked bool) error {
+	boundary := s.respParser.Pending() == 0 && !s.respSkip
+
+	s.reqMu.Lock()
+	timedOut := s.timedOut
+	if acked {
+		s.attentionAcked()
+	}
+	if hdr.IsEOM() && !s.attnSent {
+		s.stopQueryTimer()
+		s.inFlight = nil
+	}
+	if !timedOut {
+		s.responseDelivered(hdr.IsEOM(), boundary)
+	}
+	s.reqMu.Unlock()
+
+	if !timedOut {
+		return nil
+	}
+	if hdr.IsEOM() && acked {
+		if err := s.replyCancelled(); err != nil {
+			return err
+		}
+	}
+	return tds.ErrSkipPacket
+}
@@ -0,0 +1,153 @@
+package proxy
+
+import (
+	"bytes"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/joao-brasil/poc-connection-pooling/internal/config"
+	"github.com/joao-brasil/poc-connection-pooling/internal/tds"
+	"github.com/joao-brasil/poc-connection-pooling/pkg/bucket"
+)
+
+// streamingBackend responde "SELECT 1" na hora e "WAITFOR" com um pacote
+// sem EOM a cada milissegundo até receber o ATTENTION, que confirma com
+// DONE_ATTN. Requisições que chegam durante o streaming são respondidas
+// depois do DONE_ATTN.
+func streamingBackend(conn net.Conn) {
+	type message struct {
+		pktType tds.PacketType
+		payload []byte
+	}
+	msgs := make(chan message)
+	go func() {
+		defer close(msgs)
+		for {
+			pktType, payload, _, err := tds.ReadMessage(conn)
+			if err != nil {
+				return
+			}
+			msgs <- message{pktType, payload}
+		}
+	}()
+
+	doneMore := []byte{0xFD, 0x01, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0}
+	doneAttn := []byte{0xFD, 0x20, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0}
+	var pending []message
+	for {
+		var msg message
+		if len(pending) > 0 {
+			msg, pending = pending[0], pending[1:]
+		} else if m, ok := <-msgs; ok {
+			msg = m
+		} else {
+			return
+		}
+		if !bytes.Contains(msg.payload, utf16LE("WAITFOR")) {
+			tds.WritePackets(conn, tds.BuildPackets(tds.PacketReply, doneToken, 4096))
+			continue
+		}
+	stream:
+		for {
+			select {
+			case next, ok := <-msgs:
+				if !ok {
+					return
+				}
+				if !tds.IsAttention(next.pktType) {
+					pending = append(pending, next)
+					continue
+				}
+				tds.WritePackets(conn, tds.BuildPackets(tds.PacketReply, doneAttn, 4096))
+				break stream
+			case <-time.After(time.Millisecond):
+				pkt := tds.BuildPackets(tds.PacketReply, doneMore, 4096)[0]
+				pkt[1] &^= tds.StatusEOM
+				if _, err := conn.Write(pkt); err != nil {
+					return
+				}
+			}
+		}
+	}
+}
+
+// TestSessionQueryTimeout dispara o query_timeout do modo session enquanto a
+// resposta ainda está sendo retransmitida. Deve rodar com -race: o timer, a
+// goroutine do cliente e a do backend tocam o estado da requisição.
+func TestSessionQueryTimeout(t *testing.T) {
+	app, clientConn := net.Pipe()
+	backendConn, server := net.Pipe()
+	go streamingBackend(server)
+
+	cfg := &config.Config{}
+	cfg.Proxy.AttentionTimeout = time.Second
+	target := &bucket.Bucket{ID: "test", MaxConnections: 1, QueryTimeout: 20 * time.Millisecond}
+	s := newSession(clientConn, cfg, nil, nil, nil, nil, newBackendPool())
+	s.bucketID, s.target, s.mode = target.ID, target, bucket.PinningSession
+	s.backend = &backend{conn: backendConn, bucket: target, packetSize: 4096}
+
+	relayDone := make(chan struct{})
+	go func() {
+		s.packetRelay()
+		close(relayDone)
+	}()
+
+	send := func(sql string) {
+		t.Helper()
+		if err := tds.WritePackets(app, tds.BuildPackets(tds.PacketSQLBatch, tds.BuildSQLBatch(sql), 4096)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	read := func(sql string) []tds.Token {
+		t.Helper()
+		_, payload, _, err := tds.ReadMessage(app)
+		if err != nil {
+			t.Fatal(err)
+		}
+		tokens, err := tds.ParseTokens(payload)
+		if err != nil {
+			t.Fatalf("%s: response does not parse: %v", sql, err)
+		}
+		return tokens
+	}
+	query := func(sql string) []tds.Token {
+		t.Helper()
+		send(sql)
+		return read(sql)
+	}
+	timeoutError := func(tokens []tds.Token) bool {
+		for i := range tokens {
+			if number, _ := tokens[i].ErrorInfo(); tokens[i].IsError() && number == 50007 {
+				return true
+			}
+		}
+		return false
+	}
+
+	for round := range 3 {
+		if tokens := query("WAITFOR DELAY '00:01:00'"); !timeoutError(tokens) {
+			t.Errorf("round %d: no query timeout error in %d tokens", round, len(tokens))
+		}
+		if tokens := query("SELECT 1"); timeoutError(tokens) {
+			t.Errorf("round %d: query timeout reported for a fast query", round)
+		}
+	}
+
+	// Um cliente que manda a próxima requisição sem esperar a resposta faz
+	// a goroutine do cliente rearmar o timeout enquanto a do backend ainda
+	// entrega a resposta anterior.
+	send("WAITFOR DELAY '00:01:00'")
+	time.Sleep(5 * time.Millisecond)
+	send("SELECT 1")
+	if tokens := read("WAITFOR"); !timeoutError(tokens) {
+		t.Errorf("pipelined: no query timeout error in %d tokens", len(tokens))
+	}
+	if tokens := read("SELECT 1"); timeoutError(tokens) {
+		t.Error("pipelined: query timeout reported for a fast query")
+	}
+
+	app.Close()
+	backendConn.Close()
+	<-relayDone
+}
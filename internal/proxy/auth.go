@@ -0,0 +1,79 @@
+package proxy
+
+import (
+	"log"
+
+	"github.com/joao-brasil/poc-connection-pooling/internal/auth"
+	"github.com/joao-brasil/poc-connection-pooling/internal/metrics"
+	"github.com/joao-brasil/poc-connection-pooling/internal/tds"
+	"github.com/joao-brasil/poc-connection-pooling/pkg/bucket"
+)
+
+// ── Autenticação no proxy ───────────────────────────────────────────────
+//
+// Com proxy.auth_users_file configurado, o proxy valida UserName e Password
+// do Login7 contra o seu user store (internal/auth) em vez de deixar o
+// backend decidir. O Login7 segue para o backend com as credenciais de
+// serviço do bucket, de modo que a senha do RDS nunca sai do proxy. O
+// cliente recebe uma resposta de login montada pelo proxy
+// (tds.BuildClientLoginResponse): LOGINACK próprio, ENVCHANGEs de database,
+// idioma, packet size e collation e o FEATUREEXTACK; INFOs e demais
+// ENVCHANGEs do login de serviço não são repassados.
+//
+// Falhas respondem 18456 (Login failed) sem distinguir usuário
+// desconhecido, senha errada ou bucket não permitido. SSPI e FEDAUTH não
+// trazem senha e são recusados, assim como pedidos de troca de senha, que
+// o backend aplicaria ao login de serviço.
+
+// authenticate valida o login do cliente no user store. Em caso de falha o
+// cliente já recebeu o erro de login.
+func (s *Session) authenticate(login7 *tds.Login7Info) (*auth.User, bool) {
+	if login7.IntegratedSecurity || login7.Features.Has(tds.FeatureFedAuth) {
+		log.Printf("[session:%d] Proxy authentication requires SQL login, rejecting user %q", s.id, login7.UserName)
+		s.loginFailed(login7, "unrouted", "auth_unsupported")
+		return nil, false
+	}
+	if login7.ChangePassword {
+		log.Printf("[session:%d] Proxy authentication does not support password changes, rejecting user %q", s.id, login7.UserName)
+		s.loginFailed(login7, "unrouted", "auth_unsupported")
+		return nil, false
+	}
+	user, err := s.users.Authenticate(login7.UserName, login7.Password)
+	if err != nil {
+		log.Printf("[session:%d] Proxy authentication failed for user %q from %s", s.id, login7.UserName, s.ClientAddr())
+		s.loginFailed(login7, "unrouted", "auth_failed")
+		return nil, false
+	}
+	return user, true
+}
+
+// authorize verifica se o usuário pode usar o bucket e retorna o Login7 que
+// vai ao backend, com as credenciais de serviço do bucket.
+func (s *Session) authorize(user *auth.User, login7 *tds.Login7Info, payload []byte, target *bucket.Bucket) ([]byte, *tds.Login7Info, bool) {
+	if !user.Allows(target.ID) {
+		log.Printf("[session:%d] Proxy user %q is not allowed on bucket %s", s.id, user.Username, target.ID)
+		s.loginFailed(login7, target.ID, "auth_denied")
+		return nil, nil, false
+	}
+
+	backendPayload, err := tds.SetLogin7Credentials(payload, target.Username, target.Password)
+	if err != nil {
+		log.Printf("[session:%d] Rewriting Login7 credentials failed: %v", s.id, err)
+		s.sendError(tds.ErrInternalError("malformed LOGIN7 packet"))
+		return nil, nil, false
+	}
+	backendLogin, err := tds.ParseLogin7(backendPayload)
+	if err != nil {
+		log.Printf("[session:%d] Rewritten Login7 does not parse: %v", s.id, err)
+		s.sendError(tds.ErrInternalError("malformed LOGIN7 packet"))
+		return nil, nil, false
+	}
+	log.Printf("[session:%d] Proxy user %q authenticated, logging in to bucket %s as its service login", s.id, user.Username, target.ID)
+	return backendPayload, backendLogin, true
+}
+
+// loginFailed responde 18456 ao cliente e conta o erro.
+func (s *Session) loginFailed(login7 *tds.Login7Info, bucketID, reason string) {
+	s.sendError(tds.ErrLoginFailed(login7.UserName))
+	metrics.ConnectionErrors.WithLabelValues(bucketID, reason).Inc()
+}
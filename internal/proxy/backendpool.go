@@ -0,0 +1,225 @@
+package proxy
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"log"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/joao-brasil/poc-connection-pooling/internal/metrics"
+	"github.com/joao-brasil/poc-connection-pooling/internal/tds"
+	"github.com/joao-brasil/poc-connection-pooling/pkg/bucket"
+)
+
+// ── Pool de Conexões Backend Autenticadas ───────────────────────────────
+//
+// Nos modos transaction e statement, a conexão backend de uma sessão volta para
+// este pool sempre que não há transação aberta. Conexões são agrupadas por
+// chave de identidade (bucket + credenciais + database do Login7), de modo
+// que uma sessão só reutiliza conexões autenticadas com o mesmo login.
+
+// backend é uma conexão TDS autenticada com um bucket.
+type backend struct {
+	conn   net.Conn
+	key    string
+	bucket *bucket.Bucket
+
+	// packetSize negociado no login, usado nos batches do próprio proxy.
+	packetSize int
+
+	// lastSession é a sessão que usou a conexão por último. Se outra sessão
+	// a adquirir, o primeiro pacote recebe o bit RESETCONNECTION.
+	lastSession uint64
+
+	// prepared guarda os handles reais dos prepared statements preparados
+	// na conexão desde o último RESETCONNECTION (ver prepared.go).
+	prepared map[*preparedStmt]int32
+
+	// txDescriptor é o descritor da transação aberta na conexão (0 = nenhuma),
+	// vindo do ENVCHANGE de Begin Transaction.
+	txDescriptor uint64
+
+	// idleSince marca quando a conexão voltou ao pool.
+	idleSince time.Time
+
+	// release devolve o slot distribuído ocupado pela conexão; chamado uma
+	// única vez, quando ela é fechada.
+	release func()
+
+	// generation é a geração do bucket no pool quando a conexão foi aberta
+	// (ver backendPool.drain).
+	generation uint64
+}
+
+// close fecha a conexão e libera o slot distribuído associado.
+func (b *backend) close() {
+	b.conn.Close()
+	if b.release != nil {
+		b.release()
+		b.release = nil
+	}
+}
+
+// backendPool mantém conexões backend ociosas indexadas por chave de identidade.
+// Os limites vêm do bucket de cada conexão: no máximo max_connections
+// ociosas por identidade, descartadas após max_idle_time.
+type backendPool struct {
+	mu     sync.Mutex
+	idle   map[string][]*backend
+	closed bool
+
+	// generations guarda a geração atual de cada bucket. Conexões de uma
+	// geração anterior são fechadas ao serem devolvidas.
+	generations map[string]uint64
+
+	// idleCount conta as conexões ociosas de cada bucket, somando todas as
+	// chaves (métrica proxy_connections_idle).
+	idleCount map[string]int
+}
+
+// newBackendPool cria um pool vazio.
+func newBackendPool() *backendPool {
+	return &backendPool{
+		idle:        make(map[string][]*backend),
+		generations: make(map[string]uint64),
+		idleCount:   make(map[string]int),
+	}
+}
+
+// addIdle ajusta a contagem de conexões ociosas do bucket. Chamado com mu.
+func (p *backendPool) addIdle(bucketID string, n int) {
+	p.idleCount[bucketID] += n
+	metrics.ConnectionsIdle.WithLabelValues(bucketID).Set(float64(p.idleCount[bucketID]))
+}
+
+// generation retorna a geração atual do bucket, registrada nas conexões
+// abertas a partir de agora.
+func (p *backendPool) generation(bucketID string) uint64 {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.generations[bucketID]
+}
+
+// get remove e retorna a conexão ociosa mais recente para a chave (LIFO),
+// descartando as que passaram de max_idle_time. Retorna nil se não houver.
+func (p *backendPool) get(key string) *backend {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	list := p.idle[key]
+	for len(list) > 0 {
+		b := list[len(list)-1]
+		list = list[:len(list)-1]
+		p.addIdle(b.bucket.ID, -1)
+		if b.stale() {
+			b.close()
+			continue
+		}
+		p.idle[key] = list
+		return b
+	}
+	delete(p.idle, key)
+	return nil
+}
+
+// put devolve uma conexão ao pool. Se o pool estiver fechado ou cheio
+// para a chave, a conexão é fechada.
+func (p *backendPool) put(b *backend) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.closed || b.generation != p.generations[b.bucket.ID] || len(p.idle[b.key]) >= b.bucket.MaxConnections {
+		b.close()
+		return
+	}
+	b.idleSince = time.Now()
+	p.idle[b.key] = append(p.idle[b.key], b)
+	p.addIdle(b.bucket.ID, 1)
+}
+
+// evictStale fecha conexões ociosas há mais de max_idle_time.
+func (p *backendPool) evictStale() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	evicted := 0
+	for key, list := range p.idle {
+		remaining := list[:0]
+		for _, b := range list {
+			if b.stale() {
+				b.close()
+				p.addIdle(b.bucket.ID, -1)
+				evicted++
+				continue
+			}
+			remaining = append(remaining, b)
+		}
+		if len(remaining) == 0 {
+			delete(p.idle, key)
+		} else {
+			p.idle[key] = remaining
+		}
+	}
+	if evicted > 0 {
+		log.Printf("[proxy] Backend pool: evicted %d stale connections", evicted)
+	}
+}
+
+// drain fecha as conexões ociosas do bucket e avança a sua geração, de modo
+// que as conexões em uso também sejam fechadas quando voltarem ao pool.
+// Retorna o número de conexões ociosas fechadas.
+func (p *backendPool) drain(bucketID string) int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.generations[bucketID]++
+	drained := 0
+	for key, list := range p.idle {
+		if len(list) == 0 || list[0].bucket.ID != bucketID {
+			continue
+		}
+		for _, b := range list {
+			b.close()
+		}
+		drained += len(list)
+		p.addIdle(bucketID, -len(list))
+		delete(p.idle, key)
+	}
+	return drained
+}
+
+// stale indica se a conexão está ociosa há mais que o max_idle_time do bucket.
+func (b *backend) stale() bool {
+	return b.bucket.MaxIdleTime > 0 && time.Since(b.idleSince) > b.bucket.MaxIdleTime
+}
+
+// close fecha todas as conexões ociosas e recusa devoluções futuras.
+func (p *backendPool) close() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.closed = true
+	for _, list := range p.idle {
+		for _, b := range list {
+			b.close()
+			p.addIdle(b.bucket.ID, -1)
+		}
+	}
+	p.idle = nil
+}
+
+// backendKey calcula a chave de identidade de uma conexão backend: bucket e
+// os campos do Login7 que definem o contexto de segurança e o estado inicial
+// da sessão. A senha entra apenas no hash, nunca em claro na chave. As
+// feature extensions também entram: UTF-8 e session recovery, por exemplo,
+// mudam o que o servidor envia na conexão.
+func backendKey(bucketID string, login7 *tds.Login7Info) string {
+	h := sha256.New()
+	for _, field := range []string{bucketID, login7.UserName, login7.Password, login7.Database, login7.Language, login7.Features.String()} {
+		h.Write([]byte(field))
+		h.Write([]byte{0})
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
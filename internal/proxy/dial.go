@@ -0,0 +1,70 @@
+package proxy
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"math/rand/v2"
+	"net"
+	"time"
+
+	"github.com/joao-brasil/poc-connection-pooling/internal/metrics"
+	"github.com/joao-brasil/poc-connection-pooling/pkg/bucket"
+)
+
+// ── Conexão com o backend ───────────────────────────────────────────────
+//
+// Um failover Multi-AZ deixa o endpoint do RDS inacessível por alguns
+// segundos. Em vez de recusar a sessão no primeiro erro, o proxy percorre
+// host:port e os endpoints alternativos do bucket e, se todos falharem,
+// repete a lista até dial_retries vezes com backoff exponencial e jitter.
+// Todas as tentativas cabem em connection_timeout, contado desde a
+// primeira; o slot distribuído continua adquirido durante as tentativas.
+
+// maxDialBackoff limita a espera entre duas passadas pelos endpoints.
+const maxDialBackoff = 5 * time.Second
+
+// dialBackend conecta a um dos endereços do bucket e retorna a conexão e o
+// endereço usado.
+func (s *Session) dialBackend(ctx context.Context, target *bucket.Bucket) (net.Conn, string, error) {
+	timeout := target.ConnectionTimeout
+	if timeout == 0 {
+		timeout = 30 * time.Second
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	addrs := target.DialAddrs()
+	backoff := target.DialBackoff
+	var dialer net.Dialer
+	var lastErr error
+	for attempt := 0; ; attempt++ {
+		for _, addr := range addrs {
+			conn, err := dialer.DialContext(ctx, "tcp", addr)
+			if err == nil {
+				if attempt > 0 || addr != addrs[0] {
+					log.Printf("[session:%d] Backend %s reachable after %d retries (bucket %s)", s.id, addr, attempt, target.ID)
+				}
+				return conn, addr, nil
+			}
+			lastErr = err
+			log.Printf("[session:%d] Dial %s failed (bucket %s): %v", s.id, addr, target.ID, err)
+			if ctx.Err() != nil {
+				return nil, "", fmt.Errorf("dial %v: %w", addrs, lastErr)
+			}
+		}
+		if attempt >= target.DialRetries {
+			return nil, "", fmt.Errorf("dial %v after %d retries: %w", addrs, attempt, lastErr)
+		}
+
+		// Jitter "igual": metade fixa e metade aleatória do backoff.
+		wait := backoff/2 + rand.N(backoff/2+1)
+		metrics.ConnectionErrors.WithLabelValues(target.ID, "dial_retry").Inc()
+		select {
+		case <-ctx.Done():
+			return nil, "", fmt.Errorf("dial %v: %w", addrs, lastErr)
+		case <-time.After(wait):
+		}
+		backoff = min(backoff*2, maxDialBackoff)
+	}
+}
@@ -0,0 +1,190 @@
+package proxy
+
+import (
+	"context"
+	"log"
+	"net"
+	"net/netip"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/joao-brasil/poc-connection-pooling/internal/config"
+	"github.com/joao-brasil/poc-connection-pooling/internal/metrics"
+	"github.com/joao-brasil/poc-connection-pooling/pkg/bucket"
+)
+
+// ── Failover do RDS ─────────────────────────────────────────────────────
+//
+// No failover Multi-AZ o RDS troca o IP por trás do nome do endpoint. As
+// conexões já abertas continuam no servidor antigo, que passa a recusar
+// escritas (erro 3906, database read-only) ou simplesmente some. Para que
+// sessões novas cheguem ao novo primário em segundos, o proxy detecta o
+// failover de duas formas:
+//   - Resolvendo host e endpoints de cada bucket a cada
+//     dns_refresh_interval e comparando o conjunto de endereços.
+//   - Observando o erro 3906 nas respostas de um bucket primário.
+//
+// Em ambos os casos as conexões ociosas do bucket são fechadas e as em uso
+// são fechadas quando voltam ao pool, em vez de serem reutilizadas.
+
+// errNumReadOnly é o erro 3906: "Failed to update database because the
+// database is read-only", devolvido pelo antigo primário após um failover.
+const errNumReadOnly uint32 = 3906
+
+// dnsLookupTimeout limita cada resolução de um host.
+const dnsLookupTimeout = 5 * time.Second
+
+// minReadOnlyDrainInterval é o intervalo mínimo entre dois drenos por
+// read-only do mesmo bucket, mesmo com um dns_refresh_interval menor. Sem
+// ele, cada sessão que recebe o 3906 drenaria os pools de novo.
+const minReadOnlyDrainInterval = 5 * time.Second
+
+// failoverMonitor acompanha os endereços de cada bucket e drena os pools
+// quando detecta um failover.
+type failoverMonitor struct {
+	buckets  []*bucket.Bucket
+	backends *backendPool
+
+	// refresh pede uma resolução imediata do bucket (ver readOnly).
+	refresh map[string]chan struct{}
+
+	mu        sync.Mutex
+	addrs     map[string]string    // ID do bucket → endereços resolvidos
+	lastDrain map[string]time.Time // ID do bucket → último dreno por read-only
+}
+
+// newFailoverMonitor cria o monitor para os buckets configurados.
+func newFailoverMonitor(cfg *config.Config, backends *backendPool) *failoverMonitor {
+	m := &failoverMonitor{
+		backends:  backends,
+		refresh:   make(map[string]chan struct{}),
+		addrs:     make(map[string]string),
+		lastDrain: make(map[string]time.Time),
+	}
+	for i := range cfg.Buckets {
+		b := &cfg.Buckets[i]
+		m.buckets = append(m.buckets, b)
+		m.refresh[b.ID] = make(chan struct{}, 1)
+	}
+	return m
+}
+
+// run resolve periodicamente os endereços de cada bucket com nomes DNS.
+func (m *failoverMonitor) run(ctx context.Context) {
+	for _, b := range m.buckets {
+		if !hasHostnames(b) {
+			continue
+		}
+		go m.watch(ctx, b)
+	}
+}
+
+// watch resolve os endereços de um bucket a cada dns_refresh_interval ou
+// quando uma resolução imediata é pedida.
+func (m *failoverMonitor) watch(ctx context.Context, b *bucket.Bucket) {
+	m.resolve(ctx, b)
+
+	ticker := time.NewTicker(b.DNSRefreshInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		case <-m.refresh[b.ID]:
+		}
+		m.resolve(ctx, b)
+	}
+}
+
+// resolve resolve os endereços do bucket e drena os pools se mudaram desde
+// a última resolução. Em caso de erro os endereços anteriores são mantidos.
+func (m *failoverMonitor) resolve(ctx context.Context, b *bucket.Bucket) {
+	addrs, err := lookupAddrs(ctx, b)
+	if err != nil {
+		log.Printf("[failover] Bucket %s: resolving endpoints failed: %v", b.ID, err)
+		metrics.ConnectionErrors.WithLabelValues(b.ID, "dns_failed").Inc()
+		return
+	}
+
+	m.mu.Lock()
+	prev, known := m.addrs[b.ID]
+	m.addrs[b.ID] = addrs
+	m.mu.Unlock()
+
+	if known && prev != addrs {
+		log.Printf("[failover] Bucket %s: endpoint addresses changed [%s] → [%s]", b.ID, prev, addrs)
+		m.drain(b, "dns_change")
+	}
+}
+
+// readOnly trata um erro 3906 recebido de um bucket. Em um primário, o
+// servidor deixou de aceitar escritas: os pools são drenados (no máximo uma
+// vez por dns_refresh_interval, e nunca em menos de
+// minReadOnlyDrainInterval) e o DNS é resolvido de novo imediatamente.
+// Em réplicas de leitura o erro é esperado e ignorado.
+func (m *failoverMonitor) readOnly(b *bucket.Bucket) {
+	if b.IsReplica() {
+		return
+	}
+
+	interval := max(b.DNSRefreshInterval, minReadOnlyDrainInterval)
+	m.mu.Lock()
+	if time.Since(m.lastDrain[b.ID]) < interval {
+		m.mu.Unlock()
+		return
+	}
+	m.lastDrain[b.ID] = time.Now()
+	m.mu.Unlock()
+
+	log.Printf("[failover] Bucket %s: backend reported a read-only database (error %d)", b.ID, errNumReadOnly)
+	m.drain(b, "read_only")
+	select {
+	case m.refresh[b.ID] <- struct{}{}:
+	default:
+	}
+}
+
+// drain fecha as conexões ociosas do bucket e marca as em uso para serem
+// fechadas na devolução.
+func (m *failoverMonitor) drain(b *bucket.Bucket, reason string) {
+	drained := m.backends.drain(b.ID)
+	metrics.Failovers.WithLabelValues(b.ID, reason).Inc()
+	log.Printf("[failover] Bucket %s: failover detected (%s), closed %d idle connections", b.ID, reason, drained)
+}
+
+// hasHostnames indica se algum endereço do bucket é um nome DNS.
+func hasHostnames(b *bucket.Bucket) bool {
+	for _, addr := range b.DialAddrs() {
+		host, _, _ := net.SplitHostPort(addr)
+		if _, err := netip.ParseAddr(host); err != nil {
+			return true
+		}
+	}
+	return false
+}
+
+// lookupAddrs resolve host e endpoints do bucket e retorna os endereços
+// ordenados, em uma string comparável entre resoluções.
+func lookupAddrs(ctx context.Context, b *bucket.Bucket) (string, error) {
+	var addrs []string
+	for _, addr := range b.DialAddrs() {
+		host, _, _ := net.SplitHostPort(addr)
+		if _, err := netip.ParseAddr(host); err == nil {
+			addrs = append(addrs, host)
+			continue
+		}
+		lookupCtx, cancel := context.WithTimeout(ctx, dnsLookupTimeout)
+		ips, err := net.DefaultResolver.LookupHost(lookupCtx, host)
+		cancel()
+		if err != nil {
+			return "", err
+		}
+		addrs = append(addrs, ips...)
+	}
+	slices.Sort(addrs)
+	return strings.Join(slices.Compact(addrs), ", "), nil
+}
@@ -2,17 +2,25 @@ package proxy
 
 import (
 	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
 	"fmt"
 	"io"
 	"log"
+	"maps"
 	"net"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
 	"sync/atomic"
 	"time"
 
+	"github.com/joao-brasil/poc-connection-pooling/internal/auth"
 	"github.com/joao-brasil/poc-connection-pooling/internal/config"
 	"github.com/joao-brasil/poc-connection-pooling/internal/coordinator"
 	"github.com/joao-brasil/poc-connection-pooling/internal/metrics"
-	"github.com/joao-brasil/poc-connection-pooling/internal/pool"
 	"github.com/joao-brasil/poc-connection-pooling/internal/queue"
 	"github.com/joao-brasil/poc-connection-pooling/internal/tds"
 	"github.com/joao-brasil/poc-connection-pooling/pkg/bucket"
@@ -20,18 +28,28 @@ import (
 
 // ── Session Handler ─────────────────────────────────────────────────────
 //
-// Proxy TDS transparente que encaminha pacotes Pre-Login e TLS handshake
-// entre cliente e backend, evitando incompatibilidades de criptografia.
+// Proxy TDS que conclui o Pre-Login com o cliente, lê o Login7 para rotear
+// a sessão ao bucket correto e só então conecta ao backend. Com certificado
+// configurado, o proxy termina o TLS do cliente e abre seu próprio TLS com
+// o backend, de modo que Login7 e fase de dados ficam inspecionáveis.
 //
 // Ciclo de vida:
 //   1. Aceitar conexão TCP
-//   2. Ler Pre-Login do cliente → rotear para um bucket → conectar ao backend
-//   3. Encaminhar Pre-Login ao backend, retransmitir resposta ao cliente
-//   4. Retransmitir TLS handshake transparentemente (se criptografia for requerida)
-//   5. Ler Login7 (se não criptografado) para logging; caso contrário retransmitir opacamente
+//   2. Ler Pre-Login do cliente, responder e (opcionalmente) terminar TLS
+//   3. Ler Login7 do cliente → Router.Route → bucket de destino
+//   4. Adquirir slot distribuído do bucket e conectar ao backend
+//   5. Pre-Login/TLS com o backend e encaminhamento do Login7 do cliente
 //   6. Retransmitir resposta de login do backend ao cliente
-//   7. Fase de dados: relay bidirecional de pacotes com detecção de pinning
+//   7. Fase de dados conforme o pinning_mode do bucket: relay bidirecional
+//      ("session"), ou pooling em que a conexão backend volta ao pool do
+//      proxy entre transações ("transaction") ou após cada batch
+//      ("statement") e é reutilizada por outras sessões com o mesmo login,
+//      com RESETCONNECTION no primeiro pacote e o estado de sessão do
+//      cliente (database, idioma, opções SET) reaplicado
 //   8. Na desconexão: devolver/descartar conexão
+//
+// Cada conexão backend aberta ocupa um slot distribuído do bucket até ser
+// fechada, esteja ela em uso por uma sessão ou ociosa no pool.
 
 var sessionCounter atomic.Uint64
 
@@ -40,55 +58,157 @@ type Session struct {
 	id          uint64
 	clientConn  net.Conn
 	cfg         *config.Config
-	poolMgr     *pool.Manager
 	coordinator *coordinator.RedisCoordinator
 	dqueue      *queue.DistributedQueue
 	router      *Router
+	tlsConfig   *tls.Config
+	backends    *backendPool
+
+	// encryption é o modo de criptografia negociado com o cliente.
+	encryption int
+
+	// backendCAs valida o certificado TLS dos backends (nil = CAs do
+	// sistema, ver backend_tls_ca_file).
+	backendCAs *x509.CertPool
+
+	// clientAddr é o endereço real do cliente, que difere do endereço remoto
+	// de clientConn quando a conexão chega via PROXY protocol.
+	clientAddr net.Addr
+
+	// users é o user store da autenticação no proxy (nil = autenticação
+	// direta no backend, ver auth.go).
+	users *auth.Store
+
+	// listenerTarget é o bucket da listen_port em que a conexão foi aceita;
+	// com ele, o roteamento pelo Login7 não é usado.
+	listenerTarget *bucket.Bucket
+
+	// failover recebe os erros 3906 das respostas do backend (ver failover.go).
+	failover *failoverMonitor
+
+	// Handshake do cliente, reaproveitado para autenticar novas conexões
+	// backend nos modos transaction e statement.
+	preLogin     *tds.PreLoginMsg
+	loginPayload []byte
 
-	// Estado do backend.
-	bucketID    string
-	backendConn net.Conn
-	poolConn    *pool.PooledConn
+	// Estado do backend. backend é nil enquanto a sessão não segura uma
+	// conexão (entre transações, nos modos transaction e statement).
+	bucketID string
+	target   *bucket.Bucket
+	backend  *backend
+	poolKey  string
 
-	// Coordenação distribuída: se adquirimos um slot.
-	slotAcquired bool
+	// mode é o pinning_mode efetivo da sessão (bucket.PinningSession,
+	// PinningTransaction ou PinningStatement).
+	mode string
 
-	// Estado de pinning.
-	pinned    bool
-	pinReason string
+	// Parser das respostas do backend. Só é usado pela direção
+	// servidor → cliente; respSkip descarta o restante de uma resposta cujo
+	// parse falhou.
+	respParser *tds.ResponseParser
+	respSkip   bool
+
+	// Estado de sessão definido pelo cliente (database, idioma, opções
+	// SET) e a conexão backend em que ele está aplicado. Ao passar para
+	// outra conexão, o estado é reaplicado antes da próxima requisição.
+	state        *tds.SessionState
+	stateBackend *backend
+	resetPending bool // RESETCONNECTION ainda não enviado à conexão
+
+	// Prepared statements com handles virtuais (ver prepared.go).
+	prepared    map[int32]*preparedStmt
+	nextHandle  int32
+	prepCapture *prepCapture
+
+	// Estado da requisição corrente, para o tratamento de ATTENTION
+	// (ver attention.go). Protegido por reqMu.
+	reqMu     sync.Mutex
+	reqActive bool        // mensagem do cliente em processamento
+	inFlight  *backend    // backend executando a requisição
+	cancelled bool        // ATTENTION antes de a requisição chegar ao backend
+	attnSent  bool        // ATTENTION encaminhado, aguardando DONE_ATTN
+	attnTimer *time.Timer // prazo do DONE_ATTN no modo session
+
+	// query_timeout da requisição corrente e o progresso da resposta
+	// entregue ao cliente (campos resp*). Protegidos por reqMu: no modo
+	// session o progresso é zerado pela goroutine que lê o cliente e
+	// atualizado pela que lê o backend.
+	queryTimer   *time.Timer
+	timedOut     bool
+	respRelayed  bool // algum pacote da resposta já foi entregue
+	respBoundary bool // o último pacote entregue terminou em fronteira de token
+	respEOMSent  bool // o pacote EOM da resposta já foi entregue
+
+	// Estado de pinning: cada motivo ativo (transação, temp table, cursor...)
+	// e o momento em que começou. A conexão só é liberada quando todos os
+	// motivos terminam.
+	pinMu      sync.Mutex
+	pinReasons map[string]time.Time
 
 	// Rastreamento do ciclo de vida.
 	startedAt time.Time
 }
 
 // newSession cria uma nova sessão para uma conexão de cliente recebida.
-func newSession(clientConn net.Conn, cfg *config.Config, poolMgr *pool.Manager, rc *coordinator.RedisCoordinator, dq *queue.DistributedQueue, router *Router) *Session {
+func newSession(clientConn net.Conn, cfg *config.Config, rc *coordinator.RedisCoordinator, dq *queue.DistributedQueue, router *Router, tlsConfig *tls.Config, backends *backendPool) *Session {
 	return &Session{
 		id:          sessionCounter.Add(1),
 		clientConn:  clientConn,
 		cfg:         cfg,
-		poolMgr:     poolMgr,
 		coordinator: rc,
 		dqueue:      dq,
 		router:      router,
+		tlsConfig:   tlsConfig,
+		backends:    backends,
+		respParser:  tds.NewResponseParser(),
+		state:       tds.NewSessionState(),
+		prepared:    make(map[int32]*preparedStmt),
+		pinReasons:  make(map[string]time.Time),
 		startedAt:   time.Now(),
 	}
 }
 
+// ClientAddr retorna o endereço real do cliente (ver proxyproto.go).
+func (s *Session) ClientAddr() net.Addr {
+	if s.clientAddr != nil {
+		return s.clientAddr
+	}
+	return s.clientConn.RemoteAddr()
+}
+
+// logNewConnection registra a origem da sessão e a conta pela origem do
+// endereço (PROXY protocol ou conexão direta).
+func (s *Session) logNewConnection() {
+	client, remote := s.ClientAddr(), s.clientConn.RemoteAddr()
+	source := "direct"
+	if client.String() != remote.String() {
+		log.Printf("[session:%d] New connection from %s (via %s)", s.id, client, remote)
+		source = "proxy_protocol"
+	} else {
+		log.Printf("[session:%d] New connection from %s", s.id, client)
+	}
+	metrics.ClientConnections.WithLabelValues(source).Inc()
+}
+
 // Handle executa o ciclo de vida completo da sessão TDS.
 func (s *Session) Handle(ctx context.Context) {
 	defer s.cleanup()
 
-	clientAddr := s.clientConn.RemoteAddr().String()
-	log.Printf("[session:%d] New connection from %s", s.id, clientAddr)
+	s.logNewConnection()
 
-	if s.cfg.Proxy.SessionTimeout > 0 {
-		deadline := time.Now().Add(s.cfg.Proxy.SessionTimeout)
-		_ = s.clientConn.SetDeadline(deadline)
+	// Pre-Login e Login7 precisam terminar dentro de idle_timeout; na fase
+	// de dados o prazo passa a ser deslizante (idle.go).
+	if s.cfg.Proxy.IdleTimeout > 0 {
+		_ = s.clientConn.SetDeadline(time.Now().Add(s.cfg.Proxy.IdleTimeout))
 	}
 
 	// ── Passo 1: Ler Pre-Login do cliente ───────────────────────────
-	preLoginType, preLoginPayload, preLoginPackets, err := tds.ReadMessage(s.clientConn)
+	// Clientes TDS 8.0 abrem a conexão com TLS (strict.go).
+	if err := s.acceptStrictTLS(); err != nil {
+		log.Printf("[session:%d] Connection setup failed: %v", s.id, err)
+		return
+	}
+	preLoginType, preLoginPayload, _, err := tds.ReadMessage(s.clientConn)
 	if err != nil {
 		log.Printf("[session:%d] Pre-Login read failed: %v", s.id, err)
 		return
@@ -103,165 +223,789 @@ func (s *Session) Handle(ctx context.Context) {
 		return
 	}
 	log.Printf("[session:%d] Pre-Login received, encryption=0x%02X", s.id, clientPL.Encryption())
+	s.preLogin = clientPL
+
+	// A instância (INSTOPT) é validada já no Pre-Login: um nome
+	// desconhecido é recusado na fase de login (reject.go), sem backend.
+	if instance := clientPL.InstanceName(); instance != "" {
+		if _, ok := s.router.RouteInstance(instance); !ok {
+			log.Printf("[session:%d] Unknown instance %q requested in Pre-Login", s.id, instance)
+			metrics.ConnectionErrors.WithLabelValues("unrouted", "unknown_instance").Inc()
+			s.rejectLogin(clientPL, tds.ErrUnknownInstance(instance))
+			return
+		}
+	}
+
+	// ── Passo 2: Responder o Pre-Login e terminar TLS ───────────────
+	loginConn, err := s.clientHandshake(clientPL)
+	if err != nil {
+		log.Printf("[session:%d] Client handshake failed: %v", s.id, err)
+		return
+	}
+
+	// ── Passo 3: Ler Login7 e rotear para um bucket ─────────────────
+	loginType, loginPayload, _, err := tds.ReadMessage(loginConn)
+	if err != nil {
+		log.Printf("[session:%d] Login7 read failed: %v", s.id, err)
+		return
+	}
+	if loginType != tds.PacketLogin7 {
+		log.Printf("[session:%d] Expected LOGIN7, got %s", s.id, loginType)
+		return
+	}
+	login7, err := tds.ParseLogin7(loginPayload)
+	if err != nil {
+		log.Printf("[session:%d] Login7 parse failed: %v", s.id, err)
+		s.sendError(tds.ErrInternalError("malformed LOGIN7 packet"))
+		return
+	}
+	log.Printf("[session:%d] Login7: user=%q, database=%q, server=%q, app=%q",
+		s.id, login7.UserName, login7.Database, login7.ServerName, login7.AppName)
+	if len(login7.Features) > 0 {
+		log.Printf("[session:%d] Login7 features: %s (client=%q)", s.id, login7.Features, login7.ClientInterfaceName)
+	}
+
+	var user *auth.User
+	if s.users != nil {
+		var ok bool
+		if user, ok = s.authenticate(login7); !ok {
+			return
+		}
+	}
+
+	target := s.listenerTarget
+	if target != nil {
+		log.Printf("[session:%d] Routed by listener port → bucket %s", s.id, target.ID)
+	} else if target, err = s.router.Route(s.preLogin.InstanceName(), login7); err != nil {
+		log.Printf("[session:%d] Routing failed: %v", s.id, err)
+		s.sendError(tds.ErrRoutingFailed(login7.Database))
+		metrics.ConnectionErrors.WithLabelValues("unrouted", "routing_failed").Inc()
+		return
+	}
+	if !s.useBucket(target, user, login7, loginPayload) {
+		return
+	}
 
-	// ── Passo 2: Rotear para um bucket ──────────────────────────────
-	// Pre-Login não tem info de user/database; escolher o primeiro bucket.
-	// Futuro: rotear por IP do cliente, SNI ou token SSPI.
-	target := s.pickBucket()
-	if target == nil {
-		log.Printf("[session:%d] No buckets configured", s.id)
+	// ── Passos 4-6: Slot distribuído, backend e login ───────────────
+	b, err := s.openBackend(ctx, true)
+	var replicaErr *replicaDialError
+	if errors.As(err, &replicaErr) {
+		// Réplica inacessível: a sessão segue para o primário (replica.go).
+		log.Printf("[session:%d] Replica %s unreachable, falling back to primary %s: %v",
+			s.id, target.ID, replicaErr.primary.ID, replicaErr.err)
+		target = replicaErr.primary
+		if !s.useBucket(target, user, login7, loginPayload) {
+			return
+		}
+		b, err = s.openBackend(ctx, true)
+	}
+	if err != nil {
+		log.Printf("[session:%d] Backend login failed: %v", s.id, err)
 		return
 	}
+	s.backend = b
+	s.stateBackend = b
+	log.Printf("[session:%d] Login relayed to bucket %s", s.id, target.ID)
+
+	// ── Passo 7: Fase de dados ──────────────────────────────────────
+	_ = s.clientConn.SetDeadline(time.Time{})
+	metrics.ConnectionsActive.WithLabelValues(target.ID).Add(1)
+	defer metrics.ConnectionsActive.WithLabelValues(target.ID).Add(-1)
+
+	if s.mode != bucket.PinningSession {
+		log.Printf("[session:%d] Starting pooled relay (pinning_mode=%s)", s.id, s.mode)
+		s.pooledRelay(ctx)
+		return
+	}
+
+	// Com TLS terminado no proxy, clientConn/backend já são as sessões TLS
+	// decifradas e o relay pode inspecionar cada mensagem.
+	log.Printf("[session:%d] Starting packet relay", s.id)
+	s.packetRelay()
+}
+
+// useBucket direciona a sessão ao bucket target: com autenticação no proxy,
+// autoriza o usuário e troca as credenciais do Login7 pelas do bucket. Em
+// caso de falha o cliente já recebeu o erro de login.
+func (s *Session) useBucket(target *bucket.Bucket, user *auth.User, login7 *tds.Login7Info, loginPayload []byte) bool {
+	if user != nil {
+		var ok bool
+		if loginPayload, login7, ok = s.authorize(user, login7, loginPayload, target); !ok {
+			return false
+		}
+	}
 	s.bucketID = target.ID
+	s.target = target
+	s.poolKey = backendKey(target.ID, login7)
+
+	s.mode = target.PinningMode
+	if reason := sessionOnlyReason(login7); reason != "" && s.mode != bucket.PinningSession {
+		log.Printf("[session:%d] %s requires session pinning, overriding pinning_mode=%s", s.id, reason, s.mode)
+		s.mode = bucket.PinningSession
+	}
+	s.loginPayload = loginPayload
+	return true
+}
+
+// replicaDialError indica que o login não conseguiu conectar a uma réplica;
+// o cliente ainda não recebeu resposta e a sessão pode usar o primário.
+type replicaDialError struct {
+	primary *bucket.Bucket
+	err     error
+}
 
-	// ── Passo 3: Adquirir slot distribuído (Fase 3 + Fila da Fase 4) ────
+func (e *replicaDialError) Error() string { return "replica dial: " + e.err.Error() }
+func (e *replicaDialError) Unwrap() error { return e.err }
+
+// acquireSlot adquire um slot distribuído do bucket (Fase 3 + fila da Fase 4)
+// para uma nova conexão backend. Em caso de falha o erro TDS adequado já foi
+// enviado ao cliente. A função retornada devolve o slot.
+func (s *Session) acquireSlot(ctx context.Context, target *bucket.Bucket) (func(), error) {
 	if s.dqueue != nil {
 		if err := s.dqueue.Acquire(ctx, target.ID); err != nil {
 			log.Printf("[session:%d] Queue acquire failed for bucket %s: %v", s.id, target.ID, err)
 			if queue.IsQueueFull(err) {
-				s.sendError(tds.ErrQueueFull(target.ID))
+				s.sendError(s.errQueueFull(target.ID))
 				metrics.ConnectionErrors.WithLabelValues(target.ID, "queue_full").Inc()
 			} else if queue.IsQueueTimeout(err) {
-				s.sendError(tds.ErrQueueTimeout(target.ID))
+				s.sendError(s.errQueueTimeout(target.ID))
 				metrics.ConnectionErrors.WithLabelValues(target.ID, "queue_timeout").Inc()
 			} else {
-				s.sendError(tds.ErrBackendUnavailable(target.ID))
+				s.sendError(s.errBackendUnavailable(target.ID))
 				metrics.ConnectionErrors.WithLabelValues(target.ID, "coordinator_acquire_failed").Inc()
 			}
-			return
+			return nil, err
 		}
-		s.slotAcquired = true
-		log.Printf("[session:%d] Distributed slot acquired for bucket %s", s.id, target.ID)
 	} else if s.coordinator != nil {
 		// Fallback: usar coordinator diretamente se não houver dqueue (não deveria acontecer no fluxo normal)
 		if err := s.coordinator.Acquire(ctx, target.ID); err != nil {
 			log.Printf("[session:%d] Distributed acquire failed for bucket %s: %v", s.id, target.ID, err)
-			s.sendError(tds.ErrBackendUnavailable(target.ID))
+			s.sendError(s.errBackendUnavailable(target.ID))
 			metrics.ConnectionErrors.WithLabelValues(target.ID, "coordinator_acquire_failed").Inc()
-			return
+			return nil, err
 		}
-		s.slotAcquired = true
-		log.Printf("[session:%d] Distributed slot acquired for bucket %s", s.id, target.ID)
+	} else {
+		return func() {}, nil
 	}
+	log.Printf("[session:%d] Distributed slot acquired for bucket %s", s.id, target.ID)
+
+	dqueue, rc, sessionID := s.dqueue, s.coordinator, s.id
+	return func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		if dqueue != nil {
+			if err := dqueue.Release(ctx, target.ID); err != nil {
+				log.Printf("[session:%d] Distributed release (dqueue) failed for bucket %s: %v",
+					sessionID, target.ID, err)
+			}
+		} else if err := rc.Release(ctx, target.ID); err != nil {
+			log.Printf("[session:%d] Distributed release failed for bucket %s: %v",
+				sessionID, target.ID, err)
+		}
+	}, nil
+}
 
-	backendAddr := net.JoinHostPort(target.Host, fmt.Sprintf("%d", target.Port))
-	dialTimeout := target.ConnectionTimeout
-	if dialTimeout == 0 {
-		dialTimeout = 30 * time.Second
+// openBackend adquire um slot, conecta ao backend do bucket da sessão e o
+// autentica com o Login7 do cliente. Com relayLogin a resposta de login é
+// retransmitida ao cliente (login inicial); caso contrário ela só é enviada
+// se o login falhar. Em caso de erro o cliente já recebeu a resposta TDS.
+func (s *Session) openBackend(ctx context.Context, relayLogin bool) (*backend, error) {
+	target := s.target
+	release, err := s.acquireSlot(ctx, target)
+	if err != nil {
+		return nil, err
 	}
-	backendConn, err := net.DialTimeout("tcp", backendAddr, dialTimeout)
+
+	// Geração lida antes do dial: um failover durante o login já descarta
+	// a conexão na devolução (failover.go).
+	generation := s.backends.generation(target.ID)
+
+	// Endpoints e retries do bucket (dial.go).
+	conn, backendAddr, err := s.dialBackend(ctx, target)
 	if err != nil {
-		log.Printf("[session:%d] Backend dial failed (%s): %v", s.id, backendAddr, err)
-		s.sendError(tds.ErrBackendUnavailable(target.ID))
+		release()
 		metrics.ConnectionErrors.WithLabelValues(target.ID, "dial_failed").Inc()
-		return
+		if primary := s.router.replicaFailed(target); primary != nil && relayLogin {
+			// No login, Handle tenta o primário da réplica.
+			return nil, &replicaDialError{primary: primary, err: err}
+		}
+		s.sendError(s.errBackendUnavailable(target.ID))
+		return nil, err
 	}
-	s.backendConn = backendConn
+	b := &backend{conn: conn, key: s.poolKey, bucket: target, release: release, generation: generation}
 	log.Printf("[session:%d] Connected to backend %s (bucket %s)", s.id, backendAddr, target.ID)
 
-	// ── Passo 5: Encaminhar Pre-Login ao backend ────────────────────
-	if err := tds.WritePackets(s.backendConn, preLoginPackets); err != nil {
-		log.Printf("[session:%d] Failed to forward Pre-Login: %v", s.id, err)
-		return
+	// Pre-Login/TLS com o backend, validando o certificado pelo host discado.
+	serverName, _, _ := net.SplitHostPort(backendAddr)
+	loginConn, err := s.backendHandshake(b, serverName)
+	if err != nil {
+		b.close()
+		s.sendError(s.errBackendUnavailable(target.ID))
+		metrics.ConnectionErrors.WithLabelValues(target.ID, "prelogin_failed").Inc()
+		return nil, fmt.Errorf("backend prelogin: %w", err)
 	}
 
-	// ── Passo 6: Ler resposta Pre-Login do backend, encaminhar ao cliente ──
-	_, _, respPackets, err := tds.ReadMessage(s.backendConn)
+	// O Login7 é reempacotado porque, com TLS, os pacotes lidos do cliente
+	// já foram decifrados e serão cifrados novamente na sessão do backend.
+	if err := tds.WritePackets(loginConn, tds.BuildPackets(tds.PacketLogin7, s.loginPayload, 4096)); err != nil {
+		b.close()
+		s.sendError(s.errBackendUnavailable(target.ID))
+		return nil, fmt.Errorf("forwarding login7: %w", err)
+	}
+	_, respPayload, respPackets, err := tds.ReadMessage(b.conn)
 	if err != nil {
-		log.Printf("[session:%d] Backend Pre-Login response failed: %v", s.id, err)
-		return
+		b.close()
+		return nil, fmt.Errorf("reading login response: %w", err)
 	}
-	if err := tds.WritePackets(s.clientConn, respPackets); err != nil {
-		log.Printf("[session:%d] Failed to relay Pre-Login response: %v", s.id, err)
-		return
+
+	resp, err := tds.ParseLoginResponse(respPayload)
+	if err != nil {
+		// Resposta que o parser não entende: a conexão ainda pode servir a
+		// esta sessão, mas não é seguro reutilizá-la entre sessões.
+		log.Printf("[session:%d] Login response not understood, falling back to session pinning: %v", s.id, err)
+		s.mode = bucket.PinningSession
+		resp = &tds.LoginResponse{Success: relayLogin}
+	}
+	switch {
+	case !resp.Success && s.users != nil:
+		// O erro do login de serviço não vai ao cliente, que não conhece
+		// essas credenciais.
+		s.sendError(s.errBackendUnavailable(target.ID))
+	case relayLogin && s.users != nil:
+		// Autenticação no proxy: o cliente recebe a resposta montada pelo
+		// proxy, sem os tokens que descrevem o login de serviço (auth.go).
+		clientResp, err := tds.BuildClientLoginResponse(respPayload)
+		if err != nil {
+			b.close()
+			s.sendError(s.errBackendUnavailable(target.ID))
+			return nil, fmt.Errorf("building client login response: %w", err)
+		}
+		if err := tds.WritePackets(s.clientConn, tds.BuildPackets(tds.PacketReply, clientResp, 4096)); err != nil {
+			b.close()
+			return nil, fmt.Errorf("sending login response: %w", err)
+		}
+	case relayLogin || !resp.Success:
+		if err := tds.WritePackets(s.clientConn, respPackets); err != nil {
+			b.close()
+			return nil, fmt.Errorf("relaying login response: %w", err)
+		}
+	}
+	if !resp.Success {
+		b.close()
+		metrics.ConnectionErrors.WithLabelValues(target.ID, "login_failed").Inc()
+		return nil, fmt.Errorf("backend rejected login: %d %s", resp.ErrorNumber, resp.ErrorMessage)
 	}
-	log.Printf("[session:%d] Pre-Login handshake relayed", s.id)
-
-	// ── Passo 7: Relay TCP bidirecional ─────────────────────────────
-	// Após o Pre-Login, o TLS handshake + Login7 + fase de dados acontecem
-	// no mesmo stream TCP. Em vez de tentar parsear pacotes TDS
-	// durante TLS (que encapsula tudo em registros criptografados opacos),
-	// fazemos um splice TCP bruto. Isso trata transparentemente:
-	//   - TLS handshake (ClientHello, ServerHello, etc.)
-	//   - Login7 criptografado com TLS
-	//   - Resposta de login
-	//   - Fase de dados (queries, resultados)
-	//
-	// Para detecção de pinning (Fase 3+), adicionaremos parsing TDS-aware
-	// apenas no modo ENCRYPT_NOT_SUP onde os dados não são criptografados.
-	log.Printf("[session:%d] Starting bidirectional TCP relay", s.id)
-	metrics.ConnectionsActive.WithLabelValues(target.ID).Add(1)
-	defer metrics.ConnectionsActive.WithLabelValues(target.ID).Add(-1)
 
-	s.tcpRelay()
+	if relayLogin && len(resp.FeatureAcks) > 0 {
+		log.Printf("[session:%d] Backend acknowledged features: %s", s.id, resp.FeatureAcks)
+	}
+	if relayLogin {
+		// Estado inicial da sessão, que o RESETCONNECTION restaura.
+		tokens, _ := tds.ParseTokens(respPayload)
+		s.state.ObserveTokens(tokens)
+		s.state.MarkBaseline()
+	}
+	b.packetSize = resp.PacketSize
+	if b.packetSize == 0 {
+		b.packetSize = tds.DefaultPacketSize
+	}
+	b.lastSession = s.id
+	return b, nil
 }
 
-// pickBucket seleciona um bucket backend para esta sessão.
-// Como o Pre-Login não tem info de user/database, pegamos o primeiro bucket
-// ou podemos usar round-robin. Para a POC usamos bucket[0].
-// Quando roteamento Login7 for necessário pré-conexão, podemos adicionar
-// roteamento em duas fases (conectar a um backend temporário, ler Login7, depois re-rotear).
-func (s *Session) pickBucket() *bucket.Bucket {
-	if len(s.cfg.Buckets) == 0 {
-		return nil
+// sessionOnlyReason retorna por que o login não pode usar os modos
+// transaction e statement, ou "" se pode:
+//
+//   - Reabrir conexões exige repetir o Login7, o que não é possível com SSPI
+//     ou com o fluxo FEDAUTH em que o token é pedido via FEDAUTHINFO
+//     (desafio/resposta de uso único).
+//   - Com Always Encrypted os parâmetros RPC chegam cifrados e o proxy não
+//     consegue inspecioná-los nem virtualizar prepared handles.
+func sessionOnlyReason(login7 *tds.Login7Info) string {
+	if login7.IntegratedSecurity {
+		return "Integrated security"
+	}
+	if lib, ok := login7.Features.FedAuthLibrary(); ok && lib != tds.FedAuthLibrarySecurityToken {
+		return "Federated authentication handshake"
+	}
+	if login7.Features.Has(tds.FeatureColumnEncryption) {
+		return "Column encryption"
 	}
-	// Simples: usar o primeiro bucket. O Router ainda está disponível para
-	// roteamento baseado em Login7 em fases futuras.
-	b := &s.cfg.Buckets[0]
-	log.Printf("[session:%d] Picked bucket %s (default)", s.id, b.ID)
-	return b
+	return ""
 }
 
-// tcpRelay realiza cópia bruta bidirecional de bytes TCP entre cliente
-// e backend. Isso trata TLS, Login7 e a fase de dados transparentemente.
-func (s *Session) tcpRelay() {
+// pooledRelay executa a fase de dados nos modos transaction e statement.
+// Cada requisição do cliente é servida por uma conexão backend autenticada
+// com o mesmo login; ao fim da resposta, se não houver transação aberta (ou
+// outro motivo de pinning), a conexão volta ao pool do proxy.
+//
+// No modo statement, requisições que deixariam uma transação aberta são
+// recusadas com um erro TDS sem chegar ao backend.
+func (s *Session) pooledRelay(ctx context.Context) {
+	msgs := make(chan clientMessage)
 	done := make(chan struct{})
+	defer close(done)
+	go s.readClient(msgs, done)
+
+	for {
+		msg := <-msgs
+		if msg.err != nil {
+			if isIdleTimeout(msg.err) {
+				s.idleExpired()
+			} else if !isConnectionClosed(msg.err) {
+				log.Printf("[session:%d] Client read failed: %v", s.id, msg.err)
+			}
+			return
+		}
+
+		if err := s.serveRequest(ctx, msg); err != nil {
+			log.Printf("[session:%d] Request relay failed: %v", s.id, err)
+			return
+		}
+		if err := s.finishRequest(); err != nil {
+			return
+		}
+	}
+}
+
+// serveRequest executa uma requisição do cliente no modo pooled e devolve a
+// conexão ao pool se ela não ficou pinada.
+func (s *Session) serveRequest(ctx context.Context, msg clientMessage) error {
+	if s.requestCancelled() {
+		// Cancelada antes de chegar ao backend: finishRequest confirma.
+		return nil
+	}
 
-	// Cliente → Backend
-	go func() {
-		_, _ = io.Copy(s.backendConn, s.clientConn)
-		// Sinalizar a outra direção fechando o lado de escrita.
-		if tc, ok := s.backendConn.(*net.TCPConn); ok {
-			tc.CloseWrite()
+	if s.mode == bucket.PinningStatement && tds.LeavesTransactionOpen(msg.pktType, msg.payload) {
+		log.Printf("[session:%d] Rejecting multi-statement transaction (statement pooling)", s.id)
+		s.sendError(tds.ErrTransactionNotAllowed(s.bucketID))
+		metrics.ConnectionErrors.WithLabelValues(s.bucketID, "transaction_not_allowed").Inc()
+		return nil
+	}
+
+	s.applyPinResult(tds.InspectPacket(msg.pktType, msg.payload))
+	s.state.ObserveRequest(msg.pktType, msg.payload)
+	for range msg.packets {
+		s.countPacket(tds.DirectionClientToServer, msg.pktType)
+	}
+
+	if err := s.forwardRequest(ctx, msg); err != nil {
+		return err
+	}
+	if s.backend == nil {
+		// Descartada após um ATTENTION sem confirmação.
+		return nil
+	}
+
+	if s.mode == bucket.PinningStatement && s.inTransaction() {
+		// A heurística deixou passar uma transação que continua aberta:
+		// no modo statement a conexão não pode ficar presa ao cliente.
+		s.rollbackBackend()
+	}
+
+	if !s.isPinned() && s.backend != nil {
+		s.backends.put(s.backend)
+		s.backend = nil
+	}
+	return nil
+}
+
+// rollbackBackend desfaz a transação aberta na conexão backend da sessão e
+// libera o pinning. O ROLLBACK leva o descritor da transação registrado em
+// inspectResponse. Se o rollback falhar, a conexão é descartada.
+func (s *Session) rollbackBackend() {
+	log.Printf("[session:%d] Rolling back transaction left open on backend", s.id)
+	if err := tds.ExecTransactionBatch(s.backend.conn, "IF @@TRANCOUNT > 0 ROLLBACK TRANSACTION", s.backend.txDescriptor, s.backend.packetSize); err != nil {
+		log.Printf("[session:%d] Backend rollback failed, discarding connection: %v", s.id, err)
+		s.backend.close()
+		s.backend = nil
+	} else {
+		s.backend.txDescriptor = 0
+	}
+	s.applyPinResult(tds.PinResult{Action: tds.PinActionUnpin, Reason: "transaction"})
+}
+
+// forwardRequest envia uma requisição ao backend da sessão (adquirindo um do
+// pool se necessário) e retransmite a resposta completa ao cliente.
+//
+// Uma conexão ociosa pode ter sido fechada pelo servidor enquanto estava no
+// pool; se ela falhar antes de qualquer byte da resposta, a requisição é
+// repetida uma vez em uma conexão nova.
+func (s *Session) forwardRequest(ctx context.Context, msg clientMessage) error {
+	fromPool := false
+	if s.backend == nil {
+		if s.backend = s.backends.get(s.poolKey); s.backend != nil {
+			fromPool = true
+		} else if s.backend, _ = s.openBackend(ctx, false); s.backend == nil {
+			return fmt.Errorf("no backend available for bucket %s", s.bucketID)
+		}
+	}
+
+	for {
+		var err error
+		if s.backend != s.stateBackend || s.backend.lastSession != s.id {
+			err = s.prepareBackend()
+		}
+		forward := true
+		if err == nil {
+			forward, err = s.bindPrepared(msg)
+		}
+		if err == nil && !forward {
+			return nil
+		}
+		if err == nil {
+			if s.resetPending {
+				tds.SetResetConnection(msg.packets[0])
+				s.resetPending = false
+			}
+			err = s.sendRequest(msg.packets)
+		}
+		var hdr *tds.Header
+		var pkt []byte
+		if err == nil {
+			hdr, pkt, err = tds.ReadPacket(s.backend.conn)
+		}
+		if err != nil {
+			if s.attentionSent() {
+				return s.attentionFailed(err)
+			}
+			s.backend.close()
+			s.backend = nil
+			if !fromPool {
+				return err
+			}
+			log.Printf("[session:%d] Pooled backend failed, retrying on a new connection: %v", s.id, err)
+			fromPool = false
+			if s.backend, _ = s.openBackend(ctx, false); s.backend == nil {
+				return fmt.Errorf("no backend available for bucket %s", s.bucketID)
+			}
+			continue
+		}
+
+		return s.relayResponse(hdr, pkt)
+	}
+}
+
+// prepareBackend leva o estado da sessão a uma conexão que ainda não o tem.
+// Conexão usada por outra sessão precisa ser limpa (temp tables, SETs,
+// database) com RESETCONNECTION; em seguida o estado registrado da sessão é
+// reaplicado em um batch próprio. Sem estado a reaplicar, o RESETCONNECTION
+// fica pendente e vai na próxima requisição enviada à conexão.
+func (s *Session) prepareBackend() error {
+	reset := s.backend.lastSession != s.id
+	s.backend.lastSession = s.id
+	if reset {
+		// O RESETCONNECTION libera os prepared statements da conexão.
+		s.backend.prepared = nil
+	}
+
+	if replay := s.state.ReplaySQL(); replay != "" {
+		log.Printf("[session:%d] Replaying session state on backend: %s", s.id, replay)
+		if err := tds.ExecBatch(s.backend.conn, replay, s.backend.packetSize, reset); err != nil {
+			return fmt.Errorf("replaying session state: %w", err)
+		}
+	} else if reset {
+		s.resetPending = true
+	}
+	s.stateBackend = s.backend
+	return nil
+}
+
+// relayResponse retransmite ao cliente a resposta do backend a partir do
+// primeiro pacote já lido, até o fim da requisição (ver responseComplete).
+//
+// Depois de um query_timeout, os pacotes restantes são descartados e o
+// cliente recebe o erro de timeout (replyCancelled).
+func (s *Session) relayResponse(hdr *tds.Header, pkt []byte) error {
+	acked := false
+	s.reqMu.Lock()
+	s.resetResponseProgress()
+	s.reqMu.Unlock()
+	for {
+		s.countPacket(tds.DirectionServerToClient, hdr.Type)
+		if s.inspectResponse(hdr, pkt[tds.HeaderSize:]) {
+			acked = true
+		}
+		if !s.queryTimedOut() {
+			if err := s.deliverResponse(pkt, hdr.IsEOM()); err != nil {
+				s.backend.close()
+				s.backend = nil
+				return err
+			}
+			boundary := s.respParser.Pending() == 0 && !s.respSkip && !s.holdingResponse()
+			s.reqMu.Lock()
+			s.responseDelivered(hdr.IsEOM(), boundary)
+			s.reqMu.Unlock()
+		}
+		if hdr.IsEOM() && s.responseComplete(acked) {
+			s.prepCapture = nil
+			if s.queryTimedOut() {
+				return s.replyCancelled()
+			}
+			return nil
+		}
+
+		var err error
+		if hdr, pkt, err = tds.ReadPacket(s.backend.conn); err != nil {
+			if s.attentionSent() {
+				return s.attentionFailed(err)
+			}
+			s.backend.close()
+			s.backend = nil
+			return err
+		}
+	}
+}
+
+// clientHandshake responde o Pre-Login do cliente com a criptografia
+// negociada e, se houver TLS, executa o handshake encapsulado em pacotes
+// PRELOGIN. Retorna a conexão pela qual o Login7 deve ser lido.
+//
+// Com ENCRYPT_ON o restante da sessão usa a conexão TLS (s.clientConn passa
+// a ser o *tls.Conn). Com ENCRYPT_OFF apenas o Login7 trafega cifrado. Em
+// modo strict o TLS já foi estabelecido (acceptStrictTLS) e o Pre-Login é
+// só respondido.
+func (s *Session) clientHandshake(clientPL *tds.PreLoginMsg) (net.Conn, error) {
+	if s.encryption == tds.EncryptionStrict {
+		// TLS já estabelecido antes do Pre-Login.
+		respPackets := tds.BuildPackets(tds.PacketReply, tds.BuildPreLoginResponse(clientPL, tds.EncryptStrict), 4096)
+		if err := tds.WritePackets(s.clientConn, respPackets); err != nil {
+			return nil, fmt.Errorf("sending prelogin response: %w", err)
+		}
+		return s.clientConn, nil
+	}
+
+	clientEnc := clientPL.Encryption()
+	respEnc, mode := tds.NegotiateClientEncryption(clientEnc, s.tlsConfig != nil)
+	if mode == tds.EncryptionNone && (clientEnc == tds.EncryptOn || clientEnc == tds.EncryptReq) {
+		log.Printf("[session:%d] Client requires encryption (0x%02X) but TLS termination is not configured",
+			s.id, clientEnc)
+	}
+
+	respPackets := tds.BuildPackets(tds.PacketReply, tds.BuildPreLoginResponse(clientPL, respEnc), 4096)
+	if err := tds.WritePackets(s.clientConn, respPackets); err != nil {
+		return nil, fmt.Errorf("sending prelogin response: %w", err)
+	}
+	s.encryption = mode
+	if mode == tds.EncryptionNone {
+		return s.clientConn, nil
+	}
+
+	hc := tds.NewHandshakeConn(s.clientConn)
+	tlsConn := tls.Server(hc, s.tlsConfig)
+	if err := tlsConn.Handshake(); err != nil {
+		return nil, fmt.Errorf("tls handshake: %w", err)
+	}
+	if err := hc.FinishHandshake(); err != nil {
+		return nil, fmt.Errorf("tls handshake flush: %w", err)
+	}
+	log.Printf("[session:%d] Client TLS established (mode=%d)", s.id, mode)
+
+	if mode == tds.EncryptionFull {
+		s.clientConn = tlsConn
+	}
+	return tlsConn, nil
+}
+
+// backendHandshake envia ao backend o Pre-Login do cliente com a
+// criptografia escolhida pelo proxy e, se negociado, abre TLS próprio com o
+// backend. Retorna a conexão pela qual o Login7 deve ser enviado; com
+// criptografia completa, b.conn passa a ser a sessão TLS.
+//
+// O proxy sempre pede ao menos ENCRYPT_OFF, para que o Login7 (com a senha)
+// nunca trafegue em claro até o backend; se o cliente negociou ENCRYPT_ON,
+// pede criptografia completa. Com um cliente em modo strict (TDS 8.0), a
+// conexão com o backend também é strict.
+func (s *Session) backendHandshake(b *backend, serverName string) (net.Conn, error) {
+	if s.encryption == tds.EncryptionStrict {
+		backendPL := s.preLogin.Clone()
+		backendPL.ClearInstanceName()
+		conn, err := tds.StrictClientHandshake(b.conn, backendPL,
+			tds.StrictBackendTLSConfig(serverName, s.backendCAs, s.cfg.Proxy.BackendTLSSkipVerify))
+		if err != nil {
+			return nil, err
+		}
+		b.conn = conn
+		return conn, nil
+	}
+
+	sent := tds.EncryptOff
+	if s.encryption == tds.EncryptionFull {
+		sent = tds.EncryptOn
+	}
+	backendPL := s.preLogin.Clone()
+	backendPL.SetEncryption(sent)
+	backendPL.ClearInstanceName()
+
+	// Se o backend forçar criptografia completa mas o cliente negociou
+	// apenas login (ou nada), o proxy continua cifrando do lado do backend.
+	loginConn, dataConn, err := tds.ClientHandshake(b.conn, backendPL,
+		tds.BackendTLSConfig(serverName, s.backendCAs, s.cfg.Proxy.BackendTLSSkipVerify))
+	if err != nil {
+		return nil, err
+	}
+	b.conn = dataConn
+	return loginConn, nil
+}
+
+// packetRelay executa a fase de dados no modo session: relay de pacotes TDS
+// nas duas direções, inspecionando o início de cada requisição do cliente e
+// as respostas do servidor para manter o estado de pinning e as métricas.
+func (s *Session) packetRelay() {
+	s.reqMu.Lock()
+	s.armIdleDeadline()
+	s.reqMu.Unlock()
+
+	err := tds.Relay(s.clientConn, s.backend.conn, func(direction string, hdr *tds.Header, payload []byte, first bool) error {
+		s.countPacket(direction, hdr.Type)
+		s.trackRequest(direction, hdr)
+		if direction == tds.DirectionClientToServer {
+			if tds.IsAttention(hdr.Type) {
+				if !s.trackAttention() {
+					return tds.ErrSkipPacket
+				}
+				return nil
+			}
+			if first {
+				s.applyPinResult(tds.InspectPacket(hdr.Type, payload))
+			}
+			if hdr.IsEOM() {
+				s.startQueryTimer()
+			}
+			return nil
 		}
-		done <- struct{}{}
-	}()
+		return s.sessionResponse(hdr, s.inspectResponse(hdr, payload))
+	})
+	if isIdleTimeout(err) {
+		s.idleExpired()
+		return
+	}
+	if err != nil && !isConnectionClosed(err) {
+		log.Printf("[session:%d] Packet relay ended: %v", s.id, err)
+		return
+	}
+	log.Printf("[session:%d] Packet relay ended", s.id)
+}
 
-	// Backend → Cliente
-	go func() {
-		_, _ = io.Copy(s.clientConn, s.backendConn)
-		if tc, ok := s.clientConn.(*net.TCPConn); ok {
-			tc.CloseWrite()
+// inspectResponse passa um pacote de resposta do backend pelo parser de
+// tokens, aplica as mudanças de estado transacional encontradas e
+// contabiliza linhas e erros do backend.
+//
+// Retorna true se o pacote completou um DONE com DONE_ATTN.
+//
+// Se o parse falhar, o estado transacional deixa de ser confiável: a sessão
+// é pinada (a conexão não volta ao pool) e o resto da resposta é ignorado.
+func (s *Session) inspectResponse(hdr *tds.Header, payload []byte) bool {
+	acked := false
+	if !s.respSkip {
+		tokens, err := s.respParser.Feed(payload)
+		s.applyPinResult(tds.InspectTokens(tokens))
+		if desc, ok := tds.TransactionDescriptor(tokens); ok && s.backend != nil {
+			s.backend.txDescriptor = desc
 		}
-		done <- struct{}{}
-	}()
+		s.state.ObserveTokens(tokens)
+		s.capturePrepared(tokens)
+		var rows uint64
+		for i := range tokens {
+			if tokens[i].IsAttentionAck() {
+				acked = true
+			}
+			if tokens[i].HasRowCount() {
+				rows += tokens[i].RowCount
+			}
+			if tokens[i].IsError() {
+				number, _ := tokens[i].ErrorInfo()
+				metrics.BackendErrors.WithLabelValues(s.bucketID, strconv.FormatUint(uint64(number), 10)).Inc()
+				if number == errNumReadOnly && s.failover != nil {
+					s.failover.readOnly(s.target)
+				}
+			}
+		}
+		if rows > 0 {
+			metrics.BackendRows.WithLabelValues(s.bucketID).Add(float64(rows))
+		}
+		if err != nil {
+			log.Printf("[session:%d] Failed to parse backend response: %v", s.id, err)
+			s.applyPinResult(tds.PinResult{Action: tds.PinActionPin, Reason: "unparsed_response"})
+			s.respSkip = true
+		}
+	}
+	if hdr.IsEOM() {
+		if err := s.respParser.End(); err != nil && !s.respSkip {
+			log.Printf("[session:%d] Failed to parse backend response: %v", s.id, err)
+			s.applyPinResult(tds.PinResult{Action: tds.PinActionPin, Reason: "unparsed_response"})
+		}
+		s.respSkip = false
+	}
+	return acked
+}
 
-	// Aguardar pelo menos uma direção terminar.
-	<-done
-	log.Printf("[session:%d] TCP relay ended", s.id)
+// countPacket contabiliza um pacote retransmitido em TDSPacketsTotal.
+func (s *Session) countPacket(direction string, pktType tds.PacketType) {
+	metrics.TDSPacketsTotal.WithLabelValues(s.bucketID, direction, pktType.String()).Inc()
 }
 
-// applyPinResult atualiza o estado de pinning da sessão.
+// applyPinResult atualiza o estado de pinning da sessão. No modo session é
+// chamado pelas duas direções do relay ao mesmo tempo.
+//
+// Cada motivo é acompanhado separadamente: um COMMIT encerra o motivo
+// "transaction", mas uma temp table ou um cursor criados dentro da
+// transação continuam pinando a conexão.
 func (s *Session) applyPinResult(result tds.PinResult) {
+	s.pinMu.Lock()
+	defer s.pinMu.Unlock()
+
 	switch result.Action {
 	case tds.PinActionPin:
-		if !s.pinned {
-			s.pinned = true
-			s.pinReason = result.Reason
+		if _, ok := s.pinReasons[result.Reason]; !ok {
+			s.pinReasons[result.Reason] = time.Now()
 			log.Printf("[session:%d] Connection pinned: %s", s.id, result.Reason)
 			metrics.ConnectionsPinned.WithLabelValues(s.bucketID, result.Reason).Inc()
 		}
 	case tds.PinActionUnpin:
-		if s.pinned {
-			s.pinned = false
-			log.Printf("[session:%d] Connection unpinned (was: %s)", s.id, s.pinReason)
-			metrics.ConnectionsPinned.WithLabelValues(s.bucketID, s.pinReason).Dec()
-			s.pinReason = ""
+		if _, ok := s.pinReasons[result.Reason]; !ok {
+			return
+		}
+		s.clearPin(result.Reason)
+		if len(s.pinReasons) == 0 {
+			log.Printf("[session:%d] Connection unpinned (was: %s)", s.id, result.Reason)
+		} else {
+			log.Printf("[session:%d] Pin released: %s (still pinned: %s)", s.id, result.Reason, s.pinReasonList())
 		}
 	}
 }
 
-// sendError envia uma resposta de erro TDS ao cliente.
+// clearPin encerra um motivo de pinning, registrando sua duração. Chamado
+// com pinMu.
+func (s *Session) clearPin(reason string) {
+	metrics.ConnectionsPinned.WithLabelValues(s.bucketID, reason).Dec()
+	metrics.PinningDuration.WithLabelValues(s.bucketID, reason).Observe(time.Since(s.pinReasons[reason]).Seconds())
+	delete(s.pinReasons, reason)
+}
+
+// pinReasonList retorna os motivos de pinning ativos, em ordem, separados
+// por vírgula. Chamado com pinMu.
+func (s *Session) pinReasonList() string {
+	return strings.Join(slices.Sorted(maps.Keys(s.pinReasons)), ",")
+}
+
+// isPinned retorna true se há algum motivo de pinning ativo.
+func (s *Session) isPinned() bool {
+	s.pinMu.Lock()
+	defer s.pinMu.Unlock()
+	return len(s.pinReasons) > 0
+}
+
+// hasPinReason indica se a sessão está pinada pelo motivo informado.
+func (s *Session) hasPinReason(reason string) bool {
+	s.pinMu.Lock()
+	defer s.pinMu.Unlock()
+	_, ok := s.pinReasons[reason]
+	return ok
+}
+
+// sendError envia uma resposta de erro TDS ao cliente. Antes do Login7 o
+// cliente ainda não aceita um ERROR; nesse caso use rejectLogin.
 func (s *Session) sendError(errorPacket []byte) {
 	if _, err := s.clientConn.Write(errorPacket); err != nil {
 		log.Printf("[session:%d] Failed to send error to client: %v", s.id, err)
@@ -270,40 +1014,30 @@ func (s *Session) sendError(errorPacket []byte) {
 
 // cleanup fecha todas as conexões e libera recursos do pool.
 func (s *Session) cleanup() {
+	pinned := s.isPinned()
 	duration := time.Since(s.startedAt)
 	log.Printf("[session:%d] Session ended after %v (bucket=%s, pinned=%v)",
-		s.id, duration, s.bucketID, s.pinned)
+		s.id, duration, s.bucketID, pinned)
 
 	if s.clientConn != nil {
 		s.clientConn.Close()
 	}
-	if s.backendConn != nil {
-		s.backendConn.Close()
-	}
-	if s.poolConn != nil {
-		if s.pinned {
-			s.poolMgr.Discard(s.poolConn)
+	if s.backend != nil {
+		// Fora de transação a conexão está limpa e pode servir outra sessão;
+		// pinada, fechá-la faz o servidor desfazer a transação aberta.
+		if s.mode != bucket.PinningSession && !pinned && !s.attentionSent() {
+			s.backends.put(s.backend)
 		} else {
-			s.poolMgr.Release(s.poolConn)
+			s.backend.close()
 		}
 	}
 
-	// Liberar slot distribuído (Fase 3 + Fase 4).
-	if s.slotAcquired {
-		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
-		defer cancel()
-		if s.dqueue != nil {
-			if err := s.dqueue.Release(ctx, s.bucketID); err != nil {
-				log.Printf("[session:%d] Distributed release (dqueue) failed for bucket %s: %v",
-					s.id, s.bucketID, err)
-			}
-		} else if s.coordinator != nil {
-			if err := s.coordinator.Release(ctx, s.bucketID); err != nil {
-				log.Printf("[session:%d] Distributed release failed for bucket %s: %v",
-					s.id, s.bucketID, err)
-			}
-		}
+	// Pinning que durou até o fim da sessão também entra nas métricas.
+	s.pinMu.Lock()
+	for reason := range s.pinReasons {
+		s.clearPin(reason)
 	}
+	s.pinMu.Unlock()
 }
 
 // isConnectionClosed verifica se um erro indica uma conexão fechada.
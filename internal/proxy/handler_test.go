@@ -0,0 +1,179 @@
+package proxy
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"io"
+	"net"
+	"testing"
+	"unicode/utf16"
+
+	"github.com/joao-brasil/poc-connection-pooling/internal/config"
+	"github.com/joao-brasil/poc-connection-pooling/internal/metrics"
+	"github.com/joao-brasil/poc-connection-pooling/internal/tds"
+	"github.com/joao-brasil/poc-connection-pooling/pkg/bucket"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+// Tokens de resposta usados pelos backends falsos dos testes.
+var (
+	doneToken     = []byte{0xFD, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0}
+	beginTranEnv  = []byte{0xE3, 11, 0, 8, 8, 1, 0, 0, 0, 0, 0, 0, 0, 0}
+	commitTranEnv = []byte{0xE3, 11, 0, 9, 0, 8, 1, 0, 0, 0, 0, 0, 0, 0}
+	rollbackEnv   = []byte{0xE3, 11, 0, 10, 0, 8, 1, 0, 0, 0, 0, 0, 0, 0}
+)
+
+// batch monta o payload de um SQL_BATCH.
+func batch(sql string) (tds.PacketType, []byte) {
+	return tds.PacketSQLBatch, tds.BuildSQLBatch(sql)
+}
+
+// rpcByID monta o payload de um RPC_REQUEST sem parâmetros para um
+// procedimento bem conhecido (MS-TDS 2.2.6.6).
+func rpcByID(procID uint16) (tds.PacketType, []byte) {
+	payload := binary.LittleEndian.AppendUint16(tds.BuildSQLBatch(""), 0xFFFF)
+	payload = binary.LittleEndian.AppendUint16(payload, procID)
+	return tds.PacketRPCRequest, binary.LittleEndian.AppendUint16(payload, 0)
+}
+
+// utf16LE codifica s como o texto de um SQL_BATCH.
+func utf16LE(s string) []byte {
+	var out []byte
+	for _, u := range utf16.Encode([]rune(s)) {
+		out = binary.LittleEndian.AppendUint16(out, u)
+	}
+	return out
+}
+
+// TestPinReasons cobre pins criados dentro de uma transação: o fim da
+// transação só encerra o motivo "transaction".
+func TestPinReasons(t *testing.T) {
+	request := func(pktType tds.PacketType, payload []byte) tds.PinResult {
+		return tds.InspectPacket(pktType, payload)
+	}
+	begin := request(batch("BEGIN TRANSACTION"))
+	commit := request(batch("COMMIT"))
+	rollback := request(batch("ROLLBACK"))
+	tempTable := request(batch("CREATE TABLE #orders (id int)"))
+	cursorOpen := request(rpcByID(2))
+	cursorClose := request(rpcByID(9))
+	commitResponse := tds.InspectResponse(append(append([]byte(nil), commitTranEnv...), doneToken...))
+
+	tests := []struct {
+		name          string
+		steps         []tds.PinResult
+		want          string
+		inTransaction bool
+	}{
+		{"temp table created in transaction", []tds.PinResult{begin, tempTable, commit}, "temp_table", false},
+		{"cursor opened in transaction", []tds.PinResult{begin, cursorOpen, commitResponse}, "prepared", false},
+		{"cursor closed before commit", []tds.PinResult{begin, cursorOpen, cursorClose, commit}, "", false},
+		{"transaction after temp table", []tds.PinResult{tempTable, begin}, "temp_table,transaction", true},
+		{"rolled back after temp table", []tds.PinResult{tempTable, begin, rollback}, "temp_table", false},
+		{"commit without pins", []tds.PinResult{commit}, "", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newSession(nil, &config.Config{}, nil, nil, nil, nil, newBackendPool())
+			s.bucketID = "test"
+			for _, step := range tt.steps {
+				s.applyPinResult(step)
+			}
+			if got := s.pinReasonList(); got != tt.want {
+				t.Errorf("pin reasons = %q, want %q", got, tt.want)
+			}
+			if s.isPinned() != (tt.want != "") || s.inTransaction() != tt.inTransaction {
+				t.Errorf("isPinned = %v, inTransaction = %v", s.isPinned(), s.inTransaction())
+			}
+		})
+	}
+}
+
+// fakeBackend responde cada mensagem recebida com o próximo payload de
+// responses e registra os payloads das requisições.
+func fakeBackend(t *testing.T, conn net.Conn, responses [][]byte) <-chan [][]byte {
+	t.Helper()
+	received := make(chan [][]byte, 1)
+	go func() {
+		var reqs [][]byte
+		defer func() { received <- reqs }()
+		for _, resp := range responses {
+			_, payload, _, err := tds.ReadMessage(conn)
+			if err != nil {
+				return
+			}
+			reqs = append(reqs, payload)
+			if err := tds.WritePackets(conn, tds.BuildPackets(tds.PacketReply, resp, 4096)); err != nil {
+				return
+			}
+		}
+	}()
+	return received
+}
+
+// TestStatementModeCursorInTransaction executa, no modo statement, um cursor
+// cuja requisição deixa uma transação aberta: o proxy desfaz a transação,
+// mas o cursor continua pinando a conexão, que não volta ao pool.
+func TestStatementModeCursorInTransaction(t *testing.T) {
+	client, clientPeer := net.Pipe()
+	defer client.Close()
+	go io.Copy(io.Discard, clientPeer)
+	backendConn, server := net.Pipe()
+	defer backendConn.Close()
+
+	received := fakeBackend(t, server, [][]byte{
+		append(append([]byte(nil), beginTranEnv...), doneToken...), // sp_cursoropen
+		append(append([]byte(nil), rollbackEnv...), doneToken...),  // ROLLBACK do proxy
+	})
+
+	target := &bucket.Bucket{ID: "test", MaxConnections: 10, PinningMode: bucket.PinningStatement}
+	s := newSession(client, &config.Config{}, nil, nil, nil, nil, newBackendPool())
+	s.bucketID, s.target, s.mode, s.poolKey = target.ID, target, bucket.PinningStatement, "key"
+	s.backend = &backend{conn: backendConn, key: s.poolKey, bucket: target, packetSize: 4096, lastSession: s.id}
+	s.stateBackend = s.backend
+
+	pktType, payload := rpcByID(2)
+	msg := clientMessage{pktType: pktType, payload: payload, packets: tds.BuildPackets(pktType, payload, 4096)}
+	if err := s.serveRequest(context.Background(), msg); err != nil {
+		t.Fatal(err)
+	}
+	server.Close()
+
+	reqs := <-received
+	if len(reqs) != 2 || !bytes.Contains(reqs[1], utf16LE("ROLLBACK")) {
+		t.Fatalf("backend received %d requests, want the cursor and a ROLLBACK", len(reqs))
+	}
+	if got := s.pinReasonList(); got != "prepared" {
+		t.Errorf("pin reasons = %q, want prepared", got)
+	}
+	if s.backend == nil || s.backends.get(s.poolKey) != nil {
+		t.Error("backend with an open cursor was returned to the pool")
+	}
+}
+
+// TestInspectResponseMetrics confere que linhas (DONE_COUNT) e erros do
+// backend entram nas métricas do bucket, mesmo com o token dividido entre
+// dois pacotes.
+func TestInspectResponseMetrics(t *testing.T) {
+	s := newSession(nil, &config.Config{}, nil, nil, nil, nil, newBackendPool())
+	s.bucketID = "metrics-test"
+
+	rowsDone := []byte{0xFD, 0x11, 0, 0xC1, 0, 3, 0, 0, 0, 0, 0, 0, 0} // DONE_MORE|DONE_COUNT, SELECT, 3 linhas
+	errResp := tds.BuildErrorResponse(1205, 13, "deadlock victim", "db")
+	payload := append(append([]byte(nil), rowsDone...), errResp[tds.HeaderSize:]...)
+
+	split := len(rowsDone) + 5
+	s.inspectResponse(&tds.Header{Type: tds.PacketReply}, payload[:split])
+	s.inspectResponse(&tds.Header{Type: tds.PacketReply, Status: tds.StatusEOM}, payload[split:])
+
+	if got := testutil.ToFloat64(metrics.BackendRows.WithLabelValues("metrics-test")); got != 3 {
+		t.Errorf("backend rows = %v, want 3", got)
+	}
+	if got := testutil.ToFloat64(metrics.BackendErrors.WithLabelValues("metrics-test", "1205")); got != 1 {
+		t.Errorf("backend errors 1205 = %v, want 1", got)
+	}
+	if s.isPinned() {
+		t.Errorf("response parsed as unparsed: pin reasons %q", s.pinReasonList())
+	}
+}
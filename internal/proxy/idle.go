@@ -0,0 +1,102 @@
+package proxy
+
+import (
+	"errors"
+	"log"
+	"net"
+	"time"
+
+	"github.com/joao-brasil/poc-connection-pooling/internal/metrics"
+	"github.com/joao-brasil/poc-connection-pooling/internal/tds"
+	"github.com/joao-brasil/poc-connection-pooling/pkg/bucket"
+)
+
+// ── Timeouts de Ociosidade ──────────────────────────────────────────────
+//
+// Na fase de dados o prazo de leitura do cliente é deslizante: ele é
+// armado sempre que a sessão fica à espera da próxima requisição e
+// removido enquanto uma requisição está em execução, de modo que queries
+// longas não contam como ociosidade.
+//
+// O prazo é idle_timeout, ou idle_in_transaction_timeout enquanto a sessão
+// está pinada por uma transação aberta. Ao expirar, a transação é desfeita
+// (rollback no modo pooled; no modo session a conexão backend é fechada e o
+// servidor desfaz a transação), o cliente recebe um erro TDS explicando o
+// motivo e a sessão é encerrada.
+
+// inTransaction indica que a sessão está pinada por uma transação aberta.
+func (s *Session) inTransaction() bool {
+	return s.hasPinReason("transaction")
+}
+
+// idleTimeout retorna o prazo de ociosidade aplicável ao estado atual.
+func (s *Session) idleTimeout() time.Duration {
+	if s.inTransaction() {
+		return s.cfg.Proxy.IdleInTransactionTimeout
+	}
+	return s.cfg.Proxy.IdleTimeout
+}
+
+// armIdleDeadline arma o prazo de ociosidade na leitura do cliente.
+// Chamado com reqMu.
+func (s *Session) armIdleDeadline() {
+	timeout := s.idleTimeout()
+	if timeout <= 0 {
+		_ = s.clientConn.SetReadDeadline(time.Time{})
+		return
+	}
+	_ = s.clientConn.SetReadDeadline(time.Now().Add(timeout))
+}
+
+// clearIdleDeadline remove o prazo de ociosidade enquanto uma requisição
+// está em execução. Chamado com reqMu.
+func (s *Session) clearIdleDeadline() {
+	_ = s.clientConn.SetReadDeadline(time.Time{})
+}
+
+// trackRequest atualiza o prazo de ociosidade no modo session a partir do
+// callback do relay: uma mensagem completa do cliente inicia uma requisição
+// e o fim da resposta do servidor volta a sessão para ociosa.
+func (s *Session) trackRequest(direction string, hdr *tds.Header) {
+	if !hdr.IsEOM() || tds.IsAttention(hdr.Type) {
+		return
+	}
+	s.reqMu.Lock()
+	defer s.reqMu.Unlock()
+
+	if direction == tds.DirectionClientToServer {
+		s.reqActive = true
+		s.clearIdleDeadline()
+		return
+	}
+	s.reqActive = false
+	s.armIdleDeadline()
+}
+
+// isIdleTimeout indica que um erro de leitura do cliente veio do prazo de
+// ociosidade.
+func isIdleTimeout(err error) bool {
+	var netErr net.Error
+	return errors.As(err, &netErr) && netErr.Timeout()
+}
+
+// idleExpired encerra uma sessão ociosa: desfaz a transação aberta, se
+// houver, e informa o cliente. A sessão termina em seguida (cleanup).
+func (s *Session) idleExpired() {
+	timeout := s.idleTimeout()
+
+	if !s.inTransaction() {
+		log.Printf("[session:%d] Idle for %v, closing session", s.id, timeout)
+		metrics.ConnectionErrors.WithLabelValues(s.bucketID, "idle_timeout").Inc()
+		s.sendError(tds.ErrIdleTimeout(timeout))
+		return
+	}
+
+	log.Printf("[session:%d] Idle in transaction for %v, rolling back and closing session", s.id, timeout)
+	metrics.ConnectionErrors.WithLabelValues(s.bucketID, "idle_in_transaction_timeout").Inc()
+	if s.mode != bucket.PinningSession && s.backend != nil {
+		// Limpa, a conexão volta ao pool no cleanup.
+		s.rollbackBackend()
+	}
+	s.sendError(tds.ErrIdleInTransactionTimeout(timeout))
+}
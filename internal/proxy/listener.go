@@ -2,33 +2,64 @@ package proxy
 
 import (
 	"context"
+	"crypto/tls"
+	"crypto/x509"
 	"fmt"
 	"log"
 	"net"
+	"os"
 	"sync"
 	"sync/atomic"
 	"time"
 
+	"github.com/joao-brasil/poc-connection-pooling/internal/auth"
 	"github.com/joao-brasil/poc-connection-pooling/internal/config"
 	"github.com/joao-brasil/poc-connection-pooling/internal/coordinator"
-	"github.com/joao-brasil/poc-connection-pooling/internal/pool"
+	"github.com/joao-brasil/poc-connection-pooling/internal/metrics"
 	"github.com/joao-brasil/poc-connection-pooling/internal/queue"
+	"github.com/joao-brasil/poc-connection-pooling/pkg/bucket"
 )
 
 // ── Servidor TDS Proxy ────────────────────────────────────────────────
 //
 // O Server escuta em uma porta TCP (tipicamente 1433) e trata conexões
 // TDS recebidas. Cada conexão é tratada em sua própria goroutine.
+//
+// Buckets com listen_port ganham um listener próprio: sessões aceitas nele
+// já nascem roteadas para o bucket.
 
 // Server é o servidor principal do proxy TDS.
 type Server struct {
 	cfg         *config.Config
-	poolMgr     *pool.Manager
 	coordinator *coordinator.RedisCoordinator
 	dqueue      *queue.DistributedQueue
 	router      *Router
 	listener    net.Listener
 
+	// bucketListeners são os listeners das listen_port de buckets.
+	bucketListeners []bucketListener
+
+	// backends é o pool de conexões backend autenticadas dos modos transaction e statement.
+	backends *backendPool
+
+	// failover drena os pools de um bucket quando o seu endpoint muda de
+	// servidor (ver failover.go).
+	failover *failoverMonitor
+
+	// tlsConfig é usado para terminar TLS dos clientes (nil = sem TLS).
+	tlsConfig *tls.Config
+
+	// backendCAs valida o certificado TLS dos backends (nil = CAs do sistema).
+	backendCAs *x509.CertPool
+
+	// trusted são as origens que enviam cabeçalho PROXY (ver proxyproto.go);
+	// nil quando proxy_protocol está desabilitado.
+	trusted trustedProxies
+
+	// users é o user store da autenticação no proxy (ver auth.go); nil
+	// quando auth_users_file não está configurado.
+	users *auth.Store
+
 	// activeSessions rastreia o número de sessões ativas.
 	activeSessions atomic.Int64
 
@@ -43,19 +74,68 @@ type Server struct {
 }
 
 // NewServer cria um novo servidor proxy TDS.
-func NewServer(cfg *config.Config, poolMgr *pool.Manager, rc *coordinator.RedisCoordinator, dq *queue.DistributedQueue) *Server {
+func NewServer(cfg *config.Config, rc *coordinator.RedisCoordinator, dq *queue.DistributedQueue) *Server {
+	backends := newBackendPool()
 	return &Server{
 		cfg:         cfg,
-		poolMgr:     poolMgr,
 		coordinator: rc,
 		dqueue:      dq,
 		router:      NewRouter(cfg),
+		backends:    backends,
+		failover:    newFailoverMonitor(cfg, backends),
 		done:        make(chan struct{}),
 	}
 }
 
 // Start começa a escutar por conexões TDS.
 func (s *Server) Start(ctx context.Context) error {
+	if s.cfg.Proxy.TLSCertFile != "" {
+		cert, err := tls.LoadX509KeyPair(s.cfg.Proxy.TLSCertFile, s.cfg.Proxy.TLSKeyFile)
+		if err != nil {
+			return fmt.Errorf("loading TLS certificate: %w", err)
+		}
+		s.tlsConfig = &tls.Config{
+			Certificates: []tls.Certificate{cert},
+			MinVersion:   tls.VersionTLS12,
+			// TLS dentro do Pre-Login (TDS 7.x) só é suportado até TLS 1.2.
+			MaxVersion: tls.VersionTLS12,
+		}
+		log.Printf("[proxy] TLS termination enabled (cert=%s)", s.cfg.Proxy.TLSCertFile)
+	}
+
+	if s.cfg.Proxy.BackendTLSCAFile != "" {
+		pem, err := os.ReadFile(s.cfg.Proxy.BackendTLSCAFile)
+		if err != nil {
+			return fmt.Errorf("reading backend TLS CA bundle: %w", err)
+		}
+		s.backendCAs = x509.NewCertPool()
+		if !s.backendCAs.AppendCertsFromPEM(pem) {
+			return fmt.Errorf("backend TLS CA bundle %s has no PEM certificates", s.cfg.Proxy.BackendTLSCAFile)
+		}
+		log.Printf("[proxy] Backend TLS certificates verified against %s", s.cfg.Proxy.BackendTLSCAFile)
+	}
+	if s.cfg.Proxy.BackendTLSSkipVerify {
+		log.Println("[proxy] ⚠️  backend_tls_skip_verify is enabled: backend TLS certificates are NOT verified (self-signed test servers only)")
+	}
+
+	if s.cfg.Proxy.AuthUsersFile != "" {
+		users, err := auth.LoadStore(s.cfg.Proxy.AuthUsersFile)
+		if err != nil {
+			return fmt.Errorf("loading proxy users: %w", err)
+		}
+		s.users = users
+		log.Printf("[proxy] Proxy authentication enabled (%d users from %s)", users.Len(), s.cfg.Proxy.AuthUsersFile)
+	}
+
+	if s.cfg.Proxy.ProxyProtocol {
+		trusted, err := parseTrustedProxies(s.cfg.Proxy.ProxyProtocolTrustedCIDRs)
+		if err != nil {
+			return fmt.Errorf("proxy protocol: %w", err)
+		}
+		s.trusted = trusted
+		log.Printf("[proxy] PROXY protocol enabled (trusted=%v)", s.cfg.Proxy.ProxyProtocolTrustedCIDRs)
+	}
+
 	addr := fmt.Sprintf("%s:%d", s.cfg.Proxy.ListenAddr, s.cfg.Proxy.ListenPort)
 
 	listener, err := net.Listen("tcp", addr)
@@ -64,24 +144,62 @@ func (s *Server) Start(ctx context.Context) error {
 	}
 	s.listener = listener
 
+	for i := range s.cfg.Buckets {
+		b := &s.cfg.Buckets[i]
+		if b.ListenPort == 0 {
+			continue
+		}
+		bucketAddr := fmt.Sprintf("%s:%d", s.cfg.Proxy.ListenAddr, b.ListenPort)
+		l, err := net.Listen("tcp", bucketAddr)
+		if err != nil {
+			s.closeListeners()
+			return fmt.Errorf("listen on %s (bucket %s): %w", bucketAddr, b.ID, err)
+		}
+		s.bucketListeners = append(s.bucketListeners, bucketListener{Listener: l, target: b})
+		log.Printf("[proxy] Bucket %s listening on %s", b.ID, bucketAddr)
+	}
+
 	// Criar um contexto cancelável para todas as sessões.
 	ctx, cancel := context.WithCancel(ctx)
 	s.cancel = cancel
 
 	log.Printf("[proxy] TDS proxy listening on %s", addr)
 
-	// Aceitar conexões em uma goroutine.
-	go s.acceptLoop(ctx)
+	// Aceitar conexões em goroutines, uma por listener.
+	var loops sync.WaitGroup
+	loops.Add(1 + len(s.bucketListeners))
+	go func() {
+		defer loops.Done()
+		s.acceptLoop(ctx, s.listener, nil)
+	}()
+	for _, bl := range s.bucketListeners {
+		go func() {
+			defer loops.Done()
+			s.acceptLoop(ctx, bl.Listener, bl.target)
+		}()
+	}
+	go func() {
+		loops.Wait()
+		close(s.done)
+	}()
+	go s.evictLoop(ctx)
+	go s.router.replicas.probeLoop(ctx, s.cfg.Proxy.HealthCheckInterval)
+	s.failover.run(ctx)
 
 	return nil
 }
 
-// acceptLoop aceita conexões recebidas e inicia handlers de sessão.
-func (s *Server) acceptLoop(ctx context.Context) {
-	defer close(s.done)
+// bucketListener é o listener da listen_port de um bucket.
+type bucketListener struct {
+	net.Listener
+	target *bucket.Bucket
+}
 
+// acceptLoop aceita conexões recebidas e inicia handlers de sessão. Com
+// target, as sessões já nascem roteadas para o bucket.
+func (s *Server) acceptLoop(ctx context.Context, listener net.Listener, target *bucket.Bucket) {
 	for {
-		conn, err := s.listener.Accept()
+		conn, err := listener.Accept()
 		if err != nil {
 			// Verificar se o listener foi fechado (shutdown gracioso).
 			select {
@@ -108,12 +226,50 @@ func (s *Server) acceptLoop(ctx context.Context) {
 			defer s.wg.Done()
 			defer s.activeSessions.Add(-1)
 
-			session := newSession(conn, s.cfg, s.poolMgr, s.coordinator, s.dqueue, s.router)
+			clientAddr, err := s.clientAddr(conn)
+			if err != nil {
+				log.Printf("[proxy] Rejecting connection from %s: %v", conn.RemoteAddr(), err)
+				metrics.ConnectionErrors.WithLabelValues("unrouted", "proxy_protocol").Inc()
+				conn.Close()
+				return
+			}
+
+			session := newSession(conn, s.cfg, s.coordinator, s.dqueue, s.router, s.tlsConfig, s.backends)
+			session.clientAddr = clientAddr
+			session.users = s.users
+			session.backendCAs = s.backendCAs
+			session.listenerTarget = target
+			session.failover = s.failover
 			session.Handle(ctx)
 		}()
 	}
 }
 
+// clientAddr retorna o endereço real do cliente: o do cabeçalho PROXY, se a
+// conexão vem de um balanceador confiável, ou o endereço remoto da conexão.
+func (s *Server) clientAddr(conn net.Conn) (net.Addr, error) {
+	if s.trusted == nil || !s.trusted.contains(conn.RemoteAddr()) {
+		return conn.RemoteAddr(), nil
+	}
+	return readProxyHeader(conn)
+}
+
+// evictLoop fecha periodicamente conexões backend ociosas há mais que o
+// max_idle_time do bucket.
+func (s *Server) evictLoop(ctx context.Context) {
+	ticker := time.NewTicker(30 * time.Second)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			s.backends.evictStale()
+		}
+	}
+}
+
 // Stop encerra graciosamente o servidor proxy.
 // Para de aceitar novas conexões e aguarda as sessões ativas terminarem.
 func (s *Server) Stop(ctx context.Context) error {
@@ -121,9 +277,7 @@ func (s *Server) Stop(ctx context.Context) error {
 		s.activeSessions.Load())
 
 	// Parar de aceitar novas conexões.
-	if s.listener != nil {
-		s.listener.Close()
-	}
+	s.closeListeners()
 
 	// Cancelar todas as sessões.
 	if s.cancel != nil {
@@ -144,9 +298,21 @@ func (s *Server) Stop(ctx context.Context) error {
 		log.Printf("[proxy] Shutdown timeout — some sessions may have been interrupted")
 	}
 
+	s.backends.close()
+
 	return nil
 }
 
+// closeListeners fecha o listener principal e os de buckets.
+func (s *Server) closeListeners() {
+	if s.listener != nil {
+		s.listener.Close()
+	}
+	for _, bl := range s.bucketListeners {
+		bl.Close()
+	}
+}
+
 // ActiveSessions retorna o número de sessões atualmente ativas.
 func (s *Server) ActiveSessions() int64 {
 	return s.activeSessions.Load()
@@ -0,0 +1,231 @@
+package proxy
+
+import (
+	"encoding/binary"
+	"fmt"
+	"log"
+
+	"github.com/joao-brasil/poc-connection-pooling/internal/tds"
+)
+
+// ── Virtualização de Prepared Statements ────────────────────────────────
+//
+// Handles de sp_prepare/sp_prepexec pertencem à conexão backend que os
+// criou. Nos modos transaction e statement o cliente pode ser servido por
+// outra conexão a cada requisição, então o proxy entrega ao cliente handles
+// virtuais, próprios da sessão, e mantém para cada um:
+//
+//   - os parâmetros de definição (@params, @stmt e @options) como chegaram
+//     do cliente, já codificados, para preparar o statement de novo.
+//
+// O handle real fica na própria conexão backend (backend.prepared), e não
+// na sessão: o RESETCONNECTION enviado quando outra sessão pega a conexão
+// libera os handles no servidor e limpa o mapa, e uma conexão fechada leva
+// os seus handles junto.
+//
+// sp_execute e sp_unprepare têm o handle virtual trocado pelo da conexão
+// atual, preparando o statement nela sob demanda. O sp_unprepare libera o
+// handle da conexão atual; cópias em outras conexões ficam no servidor até
+// o próximo RESETCONNECTION delas.
+
+// preparedStmt é um prepared statement do cliente.
+type preparedStmt struct {
+	defs [][]byte
+}
+
+// prepCapture acompanha a resposta de um sp_prepare/sp_prepexec do cliente:
+// o handle retornado pelo backend é registrado e substituído pelo virtual
+// antes de chegar ao cliente.
+type prepCapture struct {
+	defs [][]byte
+
+	// Preenchidos quando o RETURNVALUE do handle é encontrado.
+	found   bool
+	patched bool
+	virtual int32
+	offset  int
+
+	// held é o pacote retido até o próximo (ver deliverResponse); base é
+	// o offset, na mensagem de resposta, do primeiro pacote ainda não
+	// entregue.
+	held []byte
+	base int
+}
+
+// bindPrepared adapta a requisição do cliente à conexão backend atual antes
+// do envio. Retorna false quando o próprio proxy já respondeu a requisição.
+func (s *Session) bindPrepared(msg clientMessage) (bool, error) {
+	s.prepCapture = nil
+	if msg.pktType != tds.PacketRPCRequest {
+		return true, nil
+	}
+	call, err := tds.ParseRPC(msg.payload)
+	if err != nil || call.Batched || len(call.Params) == 0 {
+		// Chamadas que o proxy não analisa seguem como vieram.
+		return true, nil
+	}
+
+	switch call.ProcName {
+	case "sp_prepare":
+		if len(call.Params) >= 3 {
+			s.prepCapture = &prepCapture{defs: paramBytes(msg.payload, call.Params[1:])}
+		}
+	case "sp_prepexec":
+		if len(call.Params) >= 3 {
+			s.prepCapture = &prepCapture{defs: paramBytes(msg.payload, call.Params[1:3])}
+		}
+	case "sp_execute":
+		stmt, _ := s.lookupPrepared(call)
+		if stmt == nil {
+			return true, nil
+		}
+		handle, err := s.backendHandle(stmt, msg.payload[:call.HeadersEnd])
+		if err != nil {
+			return true, err
+		}
+		tds.PatchPayload(msg.packets, 0, call.Params[0].ValueOffset, int32Bytes(handle))
+	case "sp_unprepare":
+		stmt, virtual := s.lookupPrepared(call)
+		if stmt == nil {
+			return true, nil
+		}
+		delete(s.prepared, virtual)
+		handle, ok := s.backend.prepared[stmt]
+		if !ok {
+			// Não existe nesta conexão: nada a liberar no servidor.
+			_, err := s.clientConn.Write(tds.BuildProcSuccess())
+			return false, err
+		}
+		delete(s.backend.prepared, stmt)
+		tds.PatchPayload(msg.packets, 0, call.Params[0].ValueOffset, int32Bytes(handle))
+	}
+	return true, nil
+}
+
+// lookupPrepared retorna o statement do handle virtual no primeiro
+// parâmetro da chamada, ou nil se o handle não é conhecido.
+func (s *Session) lookupPrepared(call *tds.RPCRequest) (*preparedStmt, int32) {
+	value := call.Params[0].Value
+	if len(value) != 4 {
+		return nil, 0
+	}
+	virtual := int32(binary.LittleEndian.Uint32(value))
+	return s.prepared[virtual], virtual
+}
+
+// backendHandle retorna o handle do statement na conexão atual, preparando-o
+// nela se necessário.
+func (s *Session) backendHandle(stmt *preparedStmt, allHeaders []byte) (int32, error) {
+	if handle, ok := s.backend.prepared[stmt]; ok {
+		return handle, nil
+	}
+
+	reset := s.resetPending
+	s.resetPending = false
+	tokens, err := tds.ExecRPC(s.backend.conn, tds.BuildPrepareRPC(allHeaders, stmt.defs), s.backend.packetSize, reset)
+	if err != nil {
+		return 0, fmt.Errorf("re-preparing statement: %w", err)
+	}
+	handle, _, ok := tds.PreparedHandle(tokens)
+	if !ok {
+		return 0, fmt.Errorf("re-preparing statement: no handle in response")
+	}
+	log.Printf("[session:%d] Statement re-prepared on backend (handle %d)", s.id, handle)
+	s.backend.setPrepared(stmt, handle)
+	return handle, nil
+}
+
+// capturePrepared registra o handle de um sp_prepare/sp_prepexec assim que
+// o RETURNVALUE aparece na resposta, criando o handle virtual.
+func (s *Session) capturePrepared(tokens []tds.Token) {
+	c := s.prepCapture
+	if c == nil || c.found {
+		return
+	}
+	handle, offset, ok := tds.PreparedHandle(tokens)
+	if !ok {
+		return
+	}
+	s.nextHandle++
+	c.found, c.virtual, c.offset = true, s.nextHandle, offset
+	stmt := &preparedStmt{defs: c.defs}
+	s.prepared[c.virtual] = stmt
+	s.backend.setPrepared(stmt, handle)
+}
+
+// setPrepared registra o handle real de um statement na conexão.
+func (b *backend) setPrepared(stmt *preparedStmt, handle int32) {
+	if b.prepared == nil {
+		b.prepared = make(map[*preparedStmt]int32)
+	}
+	b.prepared[stmt] = handle
+}
+
+// deliverResponse entrega um pacote de resposta ao cliente. Durante um
+// sp_prepare/sp_prepexec cada pacote fica retido até o próximo, já que os 4
+// bytes do handle podem atravessar dois pacotes; quando o handle aparece,
+// ele é trocado pelo virtual nos pacotes ainda não entregues.
+func (s *Session) deliverResponse(pkt []byte, eom bool) error {
+	c := s.prepCapture
+	if c == nil {
+		_, err := s.clientConn.Write(pkt)
+		return err
+	}
+
+	if c.found && !c.patched {
+		packets := [][]byte{pkt}
+		if c.held != nil {
+			packets = [][]byte{c.held, pkt}
+		}
+		tds.PatchPayload(packets, c.base, c.offset, int32Bytes(c.virtual))
+		c.patched = true
+	}
+	if err := s.flushHeld(); err != nil {
+		return err
+	}
+	if !c.patched && !eom {
+		c.held = pkt
+		return nil
+	}
+	if _, err := s.clientConn.Write(pkt); err != nil {
+		return err
+	}
+	c.base += len(pkt) - tds.HeaderSize
+	if eom {
+		// Offsets do parser recomeçam a cada mensagem.
+		c.base = 0
+	}
+	return nil
+}
+
+// holdingResponse indica que há um pacote de resposta retido.
+func (s *Session) holdingResponse() bool {
+	return s.prepCapture != nil && s.prepCapture.held != nil
+}
+
+// flushHeld entrega o pacote retido por deliverResponse, se houver.
+func (s *Session) flushHeld() error {
+	c := s.prepCapture
+	if c == nil || c.held == nil {
+		return nil
+	}
+	held := c.held
+	c.held = nil
+	c.base += len(held) - tds.HeaderSize
+	_, err := s.clientConn.Write(held)
+	return err
+}
+
+// paramBytes copia os bytes de wire dos parâmetros informados.
+func paramBytes(payload []byte, params []tds.RPCParam) [][]byte {
+	defs := make([][]byte, len(params))
+	for i, p := range params {
+		defs[i] = append([]byte(nil), payload[p.Start:p.End]...)
+	}
+	return defs
+}
+
+// int32Bytes codifica um int32 little-endian.
+func int32Bytes(v int32) []byte {
+	return binary.LittleEndian.AppendUint32(nil, uint32(v))
+}
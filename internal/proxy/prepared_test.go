@@ -0,0 +1,70 @@
+package proxy
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+
+	"github.com/joao-brasil/poc-connection-pooling/internal/config"
+	"github.com/joao-brasil/poc-connection-pooling/internal/tds"
+)
+
+// rpcWithHandle monta um RPC_REQUEST para um procedimento bem conhecido com
+// um único parâmetro int (o handle), como sp_execute e sp_unprepare.
+func rpcWithHandle(procID uint16, handle int32) clientMessage {
+	payload := binary.LittleEndian.AppendUint16(tds.BuildSQLBatch(""), 0xFFFF)
+	payload = binary.LittleEndian.AppendUint16(payload, procID)
+	payload = binary.LittleEndian.AppendUint16(payload, 0) // OptionFlags
+	payload = append(payload, 0, 0, 0x26, 4, 4)            // sem nome, status, INTN(4)
+	payload = append(payload, int32Bytes(handle)...)
+	return clientMessage{
+		pktType: tds.PacketRPCRequest,
+		payload: payload,
+		packets: tds.BuildPackets(tds.PacketRPCRequest, payload, 4096),
+	}
+}
+
+// prepareOn simula a resposta de um sp_prepare na conexão atual da sessão e
+// retorna o handle virtual entregue ao cliente.
+func prepareOn(s *Session, b *backend, handle int32) int32 {
+	s.backend = b
+	s.prepCapture = &prepCapture{}
+	s.capturePrepared([]tds.Token{{Type: 0xAC, Value: int32Bytes(handle)}})
+	return s.prepCapture.virtual
+}
+
+// TestPreparedHandlesLiveOnBackend confere que os handles reais ficam na
+// conexão backend: o sp_unprepare remove o da conexão atual e o
+// RESETCONNECTION de outra sessão descarta todos os da conexão.
+func TestPreparedHandlesLiveOnBackend(t *testing.T) {
+	s := newSession(nil, &config.Config{}, nil, nil, nil, nil, newBackendPool())
+	b1 := &backend{lastSession: s.id}
+	b2 := &backend{lastSession: s.id}
+
+	virtual := prepareOn(s, b1, 7)
+	stmt := s.prepared[virtual]
+	if stmt == nil || b1.prepared[stmt] != 7 {
+		t.Fatalf("handle not recorded on the backend: %v", b1.prepared)
+	}
+
+	msg := rpcWithHandle(15, virtual) // sp_unprepare
+	if forward, err := s.bindPrepared(msg); err != nil || !forward {
+		t.Fatalf("sp_unprepare not forwarded: %v", err)
+	}
+	if !bytes.HasSuffix(msg.packets[0], int32Bytes(7)) {
+		t.Error("sp_unprepare not patched with the backend handle")
+	}
+	if len(s.prepared) != 0 || len(b1.prepared) != 0 {
+		t.Errorf("handles left after sp_unprepare: session %d, backend %d", len(s.prepared), len(b1.prepared))
+	}
+
+	prepareOn(s, b2, 9)
+	other := newSession(nil, &config.Config{}, nil, nil, nil, nil, newBackendPool())
+	other.backend = b2
+	if err := other.prepareBackend(); err != nil {
+		t.Fatal(err)
+	}
+	if b2.prepared != nil {
+		t.Errorf("handles kept after RESETCONNECTION: %v", b2.prepared)
+	}
+}
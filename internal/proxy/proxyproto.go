@@ -0,0 +1,180 @@
+package proxy
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"net/netip"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ── PROXY protocol (v1/v2) ──────────────────────────────────────────────
+//
+// Atrás do HAProxy ou de um NLB, o endereço remoto da conexão TCP é sempre
+// o do balanceador. Com proxy_protocol habilitado, o balanceador envia um
+// cabeçalho PROXY antes do Pre-Login com o endereço real do cliente.
+//
+// Só conexões vindas de proxy_protocol_trusted_cidrs podem (e precisam)
+// trazer o cabeçalho. Para as demais, o endereço TCP é o do cliente: um
+// cabeçalho enviado por elas não é interpretado e falha como Pre-Login
+// inválido, de modo que não dá para forjar o endereço de origem.
+//
+// Formatos (https://www.haproxy.org/download/2.8/doc/proxy-protocol.txt):
+//
+//	v1: "PROXY TCP4 <src> <dst> <sport> <dport>\r\n" (texto, até 107 bytes)
+//	v2: assinatura de 12 bytes, ver/cmd, família, length (BE) e endereços
+
+// proxyHeaderTimeout limita a espera pelo cabeçalho, que o balanceador
+// envia junto com a abertura da conexão.
+const proxyHeaderTimeout = 5 * time.Second
+
+const (
+	proxyV1MaxLen = 107
+	proxyV2HdrLen = 16
+)
+
+var (
+	proxyV1Prefix = []byte("PROXY")
+	proxyV2Sig    = []byte("\r\n\r\n\x00\r\nQUIT\n")
+)
+
+// trustedProxies é a lista de origens autorizadas a enviar o cabeçalho.
+type trustedProxies []netip.Prefix
+
+// parseTrustedProxies converte a lista de CIDRs da configuração.
+func parseTrustedProxies(cidrs []string) (trustedProxies, error) {
+	var t trustedProxies
+	for _, c := range cidrs {
+		p, err := netip.ParsePrefix(c)
+		if err != nil {
+			return nil, fmt.Errorf("invalid trusted CIDR %q: %w", c, err)
+		}
+		t = append(t, p.Masked())
+	}
+	return t, nil
+}
+
+// contains verifica se o endereço remoto de uma conexão é confiável.
+func (t trustedProxies) contains(addr net.Addr) bool {
+	tcp, ok := addr.(*net.TCPAddr)
+	if !ok {
+		return false
+	}
+	ip, ok := netip.AddrFromSlice(tcp.IP)
+	if !ok {
+		return false
+	}
+	ip = ip.Unmap()
+	for _, p := range t {
+		if p.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// readProxyHeader lê o cabeçalho PROXY do início da conexão e retorna o
+// endereço do cliente. Nada além do cabeçalho é consumido. Para comandos
+// LOCAL/UNKNOWN (health checks do balanceador) retorna o próprio endereço
+// remoto da conexão.
+func readProxyHeader(conn net.Conn) (net.Addr, error) {
+	_ = conn.SetReadDeadline(time.Now().Add(proxyHeaderTimeout))
+	defer conn.SetReadDeadline(time.Time{})
+
+	start := make([]byte, len(proxyV1Prefix))
+	if _, err := io.ReadFull(conn, start); err != nil {
+		return nil, fmt.Errorf("reading proxy header: %w", err)
+	}
+	switch {
+	case bytes.Equal(start, proxyV1Prefix):
+		return readProxyV1(conn, start)
+	case bytes.Equal(start, proxyV2Sig[:len(start)]):
+		return readProxyV2(conn, start)
+	}
+	return nil, fmt.Errorf("missing proxy protocol header")
+}
+
+// readProxyV1 lê o restante da linha de texto do v1.
+func readProxyV1(conn net.Conn, start []byte) (net.Addr, error) {
+	line := append([]byte(nil), start...)
+	b := make([]byte, 1)
+	for !bytes.HasSuffix(line, []byte("\r\n")) {
+		if len(line) >= proxyV1MaxLen {
+			return nil, fmt.Errorf("proxy v1 header too long")
+		}
+		if _, err := io.ReadFull(conn, b); err != nil {
+			return nil, fmt.Errorf("reading proxy v1 header: %w", err)
+		}
+		line = append(line, b[0])
+	}
+
+	fields := strings.Fields(string(line[:len(line)-2]))
+	if len(fields) >= 2 && fields[1] == "UNKNOWN" {
+		return conn.RemoteAddr(), nil
+	}
+	if len(fields) != 6 || (fields[1] != "TCP4" && fields[1] != "TCP6") {
+		return nil, fmt.Errorf("malformed proxy v1 header %q", line)
+	}
+	ip, err := netip.ParseAddr(fields[2])
+	if err != nil {
+		return nil, fmt.Errorf("proxy v1 source address: %w", err)
+	}
+	port, err := strconv.ParseUint(fields[4], 10, 16)
+	if err != nil {
+		return nil, fmt.Errorf("proxy v1 source port: %w", err)
+	}
+	return net.TCPAddrFromAddrPort(netip.AddrPortFrom(ip, uint16(port))), nil
+}
+
+// readProxyV2 lê o cabeçalho binário do v2.
+//
+//	Byte 0-11:  assinatura
+//	Byte 12:    versão (4 bits altos, = 2) e comando (0 = LOCAL, 1 = PROXY)
+//	Byte 13:    família (0x11 = TCP/IPv4, 0x21 = TCP/IPv6)
+//	Byte 14-15: tamanho dos endereços e TLVs (big-endian)
+func readProxyV2(conn net.Conn, start []byte) (net.Addr, error) {
+	hdr := make([]byte, proxyV2HdrLen)
+	copy(hdr, start)
+	if _, err := io.ReadFull(conn, hdr[len(start):]); err != nil {
+		return nil, fmt.Errorf("reading proxy v2 header: %w", err)
+	}
+	if !bytes.Equal(hdr[:len(proxyV2Sig)], proxyV2Sig) {
+		return nil, fmt.Errorf("invalid proxy v2 signature")
+	}
+	if hdr[12]>>4 != 2 {
+		return nil, fmt.Errorf("unsupported proxy v2 version %d", hdr[12]>>4)
+	}
+	body := make([]byte, binary.BigEndian.Uint16(hdr[14:16]))
+	if _, err := io.ReadFull(conn, body); err != nil {
+		return nil, fmt.Errorf("reading proxy v2 addresses: %w", err)
+	}
+
+	switch hdr[12] & 0x0F {
+	case 0x00: // LOCAL
+		return conn.RemoteAddr(), nil
+	case 0x01: // PROXY
+	default:
+		return nil, fmt.Errorf("unsupported proxy v2 command 0x%X", hdr[12]&0x0F)
+	}
+
+	var ipLen int
+	switch hdr[13] {
+	case 0x11:
+		ipLen = 4
+	case 0x21:
+		ipLen = 16
+	default:
+		// UDP, unix socket ou UNSPEC: sem endereço TCP utilizável.
+		return conn.RemoteAddr(), nil
+	}
+	if len(body) < 2*ipLen+4 {
+		return nil, fmt.Errorf("proxy v2 address block too short (%d bytes)", len(body))
+	}
+	ip, _ := netip.AddrFromSlice(body[:ipLen])
+	port := binary.BigEndian.Uint16(body[2*ipLen : 2*ipLen+2])
+	return net.TCPAddrFromAddrPort(netip.AddrPortFrom(ip, port)), nil
+}
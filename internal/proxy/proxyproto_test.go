@@ -0,0 +1,127 @@
+package proxy
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"net"
+	"testing"
+	"testing/iotest"
+	"time"
+)
+
+// headerConn é uma net.Conn que entrega um fluxo fixo, em leituras de um
+// byte para exercitar cabeçalhos que chegam em partes.
+type headerConn struct {
+	net.Conn
+	r      io.Reader
+	remote net.Addr
+}
+
+func newHeaderConn(data []byte) (*headerConn, *bytes.Reader) {
+	src := bytes.NewReader(data)
+	return &headerConn{
+		r:      iotest.OneByteReader(src),
+		remote: &net.TCPAddr{IP: net.IPv4(10, 0, 0, 2), Port: 40000},
+	}, src
+}
+
+func (c *headerConn) Read(b []byte) (int, error)      { return c.r.Read(b) }
+func (c *headerConn) RemoteAddr() net.Addr            { return c.remote }
+func (c *headerConn) SetReadDeadline(time.Time) error { return nil }
+
+// proxyV2 monta um cabeçalho v2 com o comando, a família e o bloco de
+// endereços informados.
+func proxyV2(cmd, family byte, body []byte) []byte {
+	hdr := append([]byte(nil), proxyV2Sig...)
+	hdr = append(hdr, 0x20|cmd, family)
+	hdr = binary.BigEndian.AppendUint16(hdr, uint16(len(body)))
+	return append(hdr, body...)
+}
+
+func ipv4Body(src, dst net.IP, sport, dport uint16) []byte {
+	body := append(append([]byte(nil), src.To4()...), dst.To4()...)
+	body = binary.BigEndian.AppendUint16(body, sport)
+	return binary.BigEndian.AppendUint16(body, dport)
+}
+
+func TestReadProxyHeader(t *testing.T) {
+	prelogin := []byte{0x12, 0x01, 0x00, 0x2F}
+	ipv6 := net.ParseIP("2001:db8::7")
+	ipv6Body := append(append([]byte(nil), ipv6...), net.ParseIP("2001:db8::1")...)
+	ipv6Body = binary.BigEndian.AppendUint16(ipv6Body, 51000)
+	ipv6Body = binary.BigEndian.AppendUint16(ipv6Body, 1433)
+	withTLV := append(ipv4Body(net.IPv4(203, 0, 113, 9), net.IPv4(10, 0, 0, 1), 50123, 1433), 0x04, 0x00, 0x01, 0xAA)
+
+	tests := []struct {
+		name    string
+		header  []byte
+		want    string
+		wantErr bool
+	}{
+		{"v1 tcp4", []byte("PROXY TCP4 203.0.113.9 10.0.0.1 50123 1433\r\n"), "203.0.113.9:50123", false},
+		{"v1 tcp6", []byte("PROXY TCP6 2001:db8::7 2001:db8::1 51000 1433\r\n"), "[2001:db8::7]:51000", false},
+		{"v1 unknown", []byte("PROXY UNKNOWN\r\n"), "10.0.0.2:40000", false},
+		{"v1 malformed", []byte("PROXY TCP4 203.0.113.9\r\n"), "", true},
+		{"v1 bad port", []byte("PROXY TCP4 203.0.113.9 10.0.0.1 70000 1433\r\n"), "", true},
+		{"v1 too long", append([]byte("PROXY TCP4 "), bytes.Repeat([]byte("1"), 120)...), "", true},
+		{"v2 ipv4", proxyV2(0x1, 0x11, ipv4Body(net.IPv4(203, 0, 113, 9), net.IPv4(10, 0, 0, 1), 50123, 1433)), "203.0.113.9:50123", false},
+		{"v2 ipv6", proxyV2(0x1, 0x21, ipv6Body), "[2001:db8::7]:51000", false},
+		{"v2 with tlvs", proxyV2(0x1, 0x11, withTLV), "203.0.113.9:50123", false},
+		{"v2 local", proxyV2(0x0, 0x00, nil), "10.0.0.2:40000", false},
+		{"v2 unix socket", proxyV2(0x1, 0x31, make([]byte, 216)), "10.0.0.2:40000", false},
+		{"v2 short addresses", proxyV2(0x1, 0x11, []byte{1, 2, 3}), "", true},
+		{"v2 bad command", proxyV2(0x2, 0x11, nil), "", true},
+		{"v2 bad version", append(append([]byte(nil), proxyV2Sig...), 0x11, 0x11, 0, 0), "", true},
+		{"no header", prelogin, "", true},
+		{"truncated v2", proxyV2(0x1, 0x11, ipv4Body(net.IPv4(1, 2, 3, 4), net.IPv4(5, 6, 7, 8), 1, 2))[:20], "", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			conn, rest := newHeaderConn(append(append([]byte(nil), tt.header...), prelogin...))
+			addr, err := readProxyHeader(conn)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("accepted header, got %v", addr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if addr.String() != tt.want {
+				t.Errorf("addr = %s, want %s", addr, tt.want)
+			}
+			// O Pre-Login que segue o cabeçalho não pode ser consumido.
+			if left, _ := io.ReadAll(rest); !bytes.Equal(left, prelogin) {
+				t.Errorf("bytes left after header = %x, want %x", left, prelogin)
+			}
+		})
+	}
+}
+
+func TestTrustedProxies(t *testing.T) {
+	trusted, err := parseTrustedProxies([]string{"10.0.0.0/8", "2001:db8::/32"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		addr net.Addr
+		want bool
+	}{
+		{&net.TCPAddr{IP: net.IPv4(10, 1, 2, 3), Port: 1}, true},
+		{&net.TCPAddr{IP: net.ParseIP("::ffff:10.1.2.3"), Port: 1}, true},
+		{&net.TCPAddr{IP: net.ParseIP("2001:db8::5"), Port: 1}, true},
+		{&net.TCPAddr{IP: net.IPv4(192, 168, 0, 1), Port: 1}, false},
+		{&net.UnixAddr{Name: "/tmp/sock", Net: "unix"}, false},
+	}
+	for _, tt := range tests {
+		if got := trusted.contains(tt.addr); got != tt.want {
+			t.Errorf("contains(%s) = %v, want %v", tt.addr, got, tt.want)
+		}
+	}
+
+	if _, err := parseTrustedProxies([]string{"10.0.0.0/33"}); err == nil {
+		t.Error("invalid CIDR accepted")
+	}
+}
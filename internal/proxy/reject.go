@@ -0,0 +1,36 @@
+package proxy
+
+import (
+	"log"
+
+	"github.com/joao-brasil/poc-connection-pooling/internal/tds"
+)
+
+// ── Rejeição na fase de login ───────────────────────────────────────────
+//
+// Logo após o PRELOGIN, o driver espera a resposta do Pre-Login; um token
+// ERROR nesse ponto vira um erro genérico de protocolo e a mensagem do
+// proxy se perde. Para recusar uma sessão antes do Login7, o proxy conclui
+// um Pre-Login sintético (ENCRYPT_NOT_SUP, ou TLS quando termina TLS), lê o
+// Login7 e só então responde com ERROR + DONE, que o driver apresenta como
+// falha de login com o número e o texto do erro do proxy.
+
+// rejectLogin conclui o handshake com o cliente e responde ao Login7 com
+// errorPacket, sem abrir conexão com o backend.
+func (s *Session) rejectLogin(clientPL *tds.PreLoginMsg, errorPacket []byte) {
+	loginConn, err := s.clientHandshake(clientPL)
+	if err != nil {
+		log.Printf("[session:%d] Client handshake failed while rejecting session: %v", s.id, err)
+		return
+	}
+	loginType, _, _, err := tds.ReadMessage(loginConn)
+	if err != nil {
+		log.Printf("[session:%d] Login7 read failed while rejecting session: %v", s.id, err)
+		return
+	}
+	if loginType != tds.PacketLogin7 {
+		log.Printf("[session:%d] Expected LOGIN7, got %s", s.id, loginType)
+		return
+	}
+	s.sendError(errorPacket)
+}
@@ -0,0 +1,150 @@
+package proxy
+
+import (
+	"context"
+	"log"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/joao-brasil/poc-connection-pooling/internal/config"
+	"github.com/joao-brasil/poc-connection-pooling/internal/metrics"
+	"github.com/joao-brasil/poc-connection-pooling/pkg/bucket"
+)
+
+// ── Réplicas de Leitura ─────────────────────────────────────────────────
+//
+// Um bucket com replica_of é uma réplica de leitura do primário indicado.
+// Cada réplica é um bucket completo (slots no coordinator, pool, métricas
+// com o próprio bucket_id); o router apenas decide quando usá-la.
+//
+// A saúde das réplicas é acompanhada de duas formas:
+//   - Probe TCP periódico (health_check_interval), que também devolve à
+//     rotação réplicas que voltaram.
+//   - Falha de dial de uma sessão, que tira a réplica da rotação até o
+//     próximo probe bem-sucedido. No login a sessão segue então para o
+//     primário, como quando não há réplica saudável.
+
+// replicaProbeTimeout limita o dial do probe de uma réplica.
+const replicaProbeTimeout = 5 * time.Second
+
+// replicaSet guarda as réplicas de cada primário e a saúde de cada uma.
+type replicaSet struct {
+	byPrimary map[string][]*bucket.Bucket
+
+	mu   sync.Mutex
+	down map[string]bool   // ID da réplica → fora de rotação
+	next map[string]uint64 // ID do primário → próxima réplica (round-robin)
+}
+
+// newReplicaSet agrupa as réplicas configuradas por primário.
+func newReplicaSet(cfg *config.Config) *replicaSet {
+	rs := &replicaSet{
+		byPrimary: make(map[string][]*bucket.Bucket),
+		down:      make(map[string]bool),
+		next:      make(map[string]uint64),
+	}
+	for i := range cfg.Buckets {
+		b := &cfg.Buckets[i]
+		if b.IsReplica() {
+			rs.byPrimary[b.ReplicaOf] = append(rs.byPrimary[b.ReplicaOf], b)
+			metrics.ReplicaHealthy.WithLabelValues(b.ID).Set(1)
+		}
+	}
+	return rs
+}
+
+// has indica se o primário tem réplicas configuradas.
+func (rs *replicaSet) has(primaryID string) bool {
+	return len(rs.byPrimary[primaryID]) > 0
+}
+
+// pick escolhe, em round-robin, uma réplica saudável do primário. Retorna
+// nil se não houver nenhuma.
+func (rs *replicaSet) pick(primaryID string) *bucket.Bucket {
+	replicas := rs.byPrimary[primaryID]
+	if len(replicas) == 0 {
+		return nil
+	}
+
+	rs.mu.Lock()
+	defer rs.mu.Unlock()
+	start := rs.next[primaryID]
+	for i := range uint64(len(replicas)) {
+		b := replicas[(start+i)%uint64(len(replicas))]
+		if !rs.down[b.ID] {
+			rs.next[primaryID] = start + i + 1
+			return b
+		}
+	}
+	return nil
+}
+
+// setHealthy coloca uma réplica na rotação ou a retira dela.
+func (rs *replicaSet) setHealthy(b *bucket.Bucket, healthy bool) {
+	rs.mu.Lock()
+	changed := rs.down[b.ID] == healthy
+	rs.down[b.ID] = !healthy
+	rs.mu.Unlock()
+
+	if !changed {
+		return
+	}
+	if healthy {
+		log.Printf("[router] Replica %s (%s) is back in rotation", b.ID, b.Addr())
+		metrics.ReplicaHealthy.WithLabelValues(b.ID).Set(1)
+	} else {
+		log.Printf("[router] Replica %s (%s) removed from rotation", b.ID, b.Addr())
+		metrics.ReplicaHealthy.WithLabelValues(b.ID).Set(0)
+	}
+}
+
+// probeLoop verifica periodicamente se cada réplica aceita conexões TCP.
+func (rs *replicaSet) probeLoop(ctx context.Context, interval time.Duration) {
+	if len(rs.byPrimary) == 0 {
+		return
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			for _, replicas := range rs.byPrimary {
+				for _, b := range replicas {
+					rs.setHealthy(b, probeReplica(ctx, b))
+				}
+			}
+		}
+	}
+}
+
+// probeReplica tenta abrir uma conexão TCP com algum dos endereços da
+// réplica (host:port e endpoints), como o dial das sessões.
+func probeReplica(ctx context.Context, b *bucket.Bucket) bool {
+	timeout := replicaProbeTimeout
+	if b.ConnectionTimeout > 0 && b.ConnectionTimeout < timeout {
+		timeout = b.ConnectionTimeout
+	}
+	dialer := net.Dialer{Timeout: timeout}
+	for _, addr := range b.DialAddrs() {
+		conn, err := dialer.DialContext(ctx, "tcp", addr)
+		if err == nil {
+			conn.Close()
+			return true
+		}
+	}
+	return false
+}
+
+// replicaFailed retira uma réplica da rotação após uma falha de conexão e
+// retorna o primário dela, ou nil se b não é uma réplica.
+func (r *Router) replicaFailed(b *bucket.Bucket) *bucket.Bucket {
+	if !b.IsReplica() {
+		return nil
+	}
+	r.replicas.setHealthy(b, false)
+	return r.byID[b.ReplicaOf]
+}
@@ -14,13 +14,23 @@ import (
 //
 // O router mapeia um pacote Login7 para um bucket de destino. Estratégias de roteamento:
 //
+// 0. Por instância        — Pre-Login INSTOPT (proxyhost\bucket-007) → ID do bucket
 // 1. Por nome do banco   — Login7.Database → bucket com database correspondente
 // 2. Por nome do servidor — Login7.ServerName → ID do bucket
 // 3. Por nome de usuário  — Login7.UserName → bucket com username correspondente
 // 4. Primeiro match vence — fallback: se existir apenas um bucket, usá-lo
 //
+// A instância chega em claro no Pre-Login, antes de qualquer TLS, então
+// funciona mesmo sem terminação TLS no proxy: basta o cliente trocar a
+// instância na connection string para escolher o bucket.
+//
 // Para a POC, todos os buckets compartilham o mesmo nome de banco ("tenant_db"), então
 // usamos nome do servidor ou username como chaves de roteamento alternativas.
+//
+// Réplicas (replica_of) não participam dessas estratégias: uma sessão com
+// ApplicationIntent=ReadOnly roteada a um primário é desviada para uma de
+// suas réplicas saudáveis (ver replica.go), ou fica no primário se não
+// houver nenhuma.
 
 // Router resolve um pacote Login7 para um bucket de destino.
 type Router struct {
@@ -38,8 +48,14 @@ type Router struct {
 	// byID mapeia ID do bucket → bucket para lookup direto.
 	byID map[string]*bucket.Bucket
 
+	// byInstance mapeia nome de instância (ID do bucket, minúsculo) → bucket.
+	byInstance map[string]*bucket.Bucket
+
 	// defaultBucket é usado quando há apenas um bucket ou nenhum match de roteamento.
 	defaultBucket *bucket.Bucket
+
+	// replicas mapeia ID do bucket primário → réplicas de leitura.
+	replicas *replicaSet
 }
 
 // NewRouter cria um Router a partir da configuração.
@@ -50,13 +66,21 @@ func NewRouter(cfg *config.Config) *Router {
 		byServerName: make(map[string]*bucket.Bucket),
 		byHost:       make(map[string]*bucket.Bucket),
 		byID:         make(map[string]*bucket.Bucket),
+		byInstance:   make(map[string]*bucket.Bucket),
+		replicas:     newReplicaSet(cfg),
 	}
 
 	// Construir mapas de lookup.
 	seenDBs := make(map[string]int) // rastrear duplicatas
+	var primaries []*bucket.Bucket
 	for i := range cfg.Buckets {
 		b := &cfg.Buckets[i]
 		r.byID[b.ID] = b
+		r.byInstance[strings.ToLower(b.ID)] = b
+		if b.IsReplica() {
+			continue
+		}
+		primaries = append(primaries, b)
 		r.byHost[b.Addr()] = b
 		seenDBs[b.Database]++
 
@@ -68,27 +92,65 @@ func NewRouter(cfg *config.Config) *Router {
 	}
 
 	// Só preencher byDatabase se nomes de banco forem únicos entre buckets.
-	for i := range cfg.Buckets {
-		b := &cfg.Buckets[i]
+	for _, b := range primaries {
 		if seenDBs[b.Database] == 1 {
 			r.byDatabase[strings.ToLower(b.Database)] = b
 		}
 	}
 
 	// Se houver apenas um bucket, definir como padrão.
-	if len(cfg.Buckets) == 1 {
-		r.defaultBucket = &cfg.Buckets[0]
+	if len(primaries) == 1 {
+		r.defaultBucket = primaries[0]
 	}
 
-	log.Printf("[router] Initialized: %d buckets, %d unique databases, %d server aliases",
-		len(cfg.Buckets), len(r.byDatabase), len(r.byServerName))
+	log.Printf("[router] Initialized: %d buckets, %d replicas, %d unique databases, %d server aliases",
+		len(primaries), len(cfg.Buckets)-len(primaries), len(r.byDatabase), len(r.byServerName))
 
 	return r
 }
 
-// Route resolve um pacote Login7 para um bucket de destino.
+// defaultInstance é o nome que drivers enviam no INSTOPT quando a connection
+// string não indica instância.
+const defaultInstance = "mssqlserver"
+
+// RouteInstance resolve o nome de instância do Pre-Login (INSTOPT). Retorna
+// false se uma instância foi pedida e não corresponde a nenhum bucket; o
+// bucket é nil quando nenhuma instância foi pedida.
+func (r *Router) RouteInstance(instance string) (*bucket.Bucket, bool) {
+	name := strings.ToLower(instance)
+	if name == "" || name == defaultInstance {
+		return nil, true
+	}
+	b, ok := r.byInstance[name]
+	return b, ok
+}
+
+// Route resolve um pacote Login7 para um bucket de destino. instance é o
+// nome de instância do Pre-Login, já validado por RouteInstance.
 // Retorna o bucket e nil de erro, ou nil e um erro se nenhuma rota foi encontrada.
-func (r *Router) Route(login7 *tds.Login7Info) (*bucket.Bucket, error) {
+func (r *Router) Route(instance string, login7 *tds.Login7Info) (*bucket.Bucket, error) {
+	b, err := r.routePrimary(instance, login7)
+	if err != nil || !login7.ReadOnlyIntent || b.IsReplica() {
+		return b, err
+	}
+	if replica := r.replicas.pick(b.ID); replica != nil {
+		log.Printf("[router] Read-only intent → replica %s of bucket %s", replica.ID, b.ID)
+		return replica, nil
+	}
+	if r.replicas.has(b.ID) {
+		log.Printf("[router] Read-only intent: no healthy replica of bucket %s, using primary", b.ID)
+	}
+	return b, nil
+}
+
+// routePrimary aplica as estratégias de roteamento à instância e ao Login7.
+func (r *Router) routePrimary(instance string, login7 *tds.Login7Info) (*bucket.Bucket, error) {
+	// Estratégia 0: Rotear pela instância pedida no Pre-Login.
+	if b, _ := r.RouteInstance(instance); b != nil {
+		log.Printf("[router] Routed by instance name %q → bucket %s", instance, b.ID)
+		return b, nil
+	}
+
 	// Estratégia 1: Rotear por nome do servidor (mais explícito).
 	// O cliente pode definir o nome do servidor como o ID do bucket para rotear explicitamente.
 	if login7.ServerName != "" {
@@ -118,7 +180,7 @@ func (r *Router) Route(login7 *tds.Login7Info) (*bucket.Bucket, error) {
 	if login7.UserName != "" {
 		for i := range r.cfg.Buckets {
 			b := &r.cfg.Buckets[i]
-			if strings.EqualFold(b.Username, login7.UserName) {
+			if !b.IsReplica() && strings.EqualFold(b.Username, login7.UserName) {
 				log.Printf("[router] Routed by username %q → bucket %s", login7.UserName, b.ID)
 				return b, nil
 			}
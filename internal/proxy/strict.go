@@ -0,0 +1,66 @@
+package proxy
+
+import (
+	"crypto/tls"
+	"fmt"
+	"io"
+	"log"
+	"net"
+
+	"github.com/joao-brasil/poc-connection-pooling/internal/tds"
+)
+
+// ── TDS 8.0 (Encrypt=Strict) ────────────────────────────────────────────
+//
+// Clientes em modo strict abrem a conexão com um ClientHello em vez de um
+// Pre-Login. O primeiro byte recebido distingue os dois casos: com TLS, o
+// proxy termina o túnel (ALPN "tds/8.0") e o restante do fluxo — Pre-Login,
+// Login7 e fase de dados — segue normalmente dentro dele. A conexão com o
+// backend também usa TLS strict, de modo que nenhum trecho fica sem a
+// proteção exigida pelo cliente.
+
+// acceptStrictTLS inspeciona o primeiro byte da conexão do cliente e, se for
+// um ClientHello, termina o TLS strict. Ao retornar, s.clientConn é a
+// conexão pela qual o Pre-Login deve ser lido.
+func (s *Session) acceptStrictTLS() error {
+	first := make([]byte, 1)
+	if _, err := io.ReadFull(s.clientConn, first); err != nil {
+		return fmt.Errorf("reading first byte: %w", err)
+	}
+	conn := &prefixConn{Conn: s.clientConn, prefix: first}
+	s.clientConn = conn
+	if !tds.IsTLSHandshake(first[0]) {
+		return nil
+	}
+
+	if s.tlsConfig == nil {
+		return fmt.Errorf("client requires strict encryption but TLS termination is not configured")
+	}
+	tlsConn := tls.Server(conn, tds.StrictServerTLSConfig(s.tlsConfig))
+	if err := tlsConn.Handshake(); err != nil {
+		return fmt.Errorf("strict tls handshake: %w", err)
+	}
+	if proto := tlsConn.ConnectionState().NegotiatedProtocol; proto != tds.ALPNStrict {
+		// O ALPN é obrigatório no TDS 8.0, mas nem todo cliente o envia.
+		log.Printf("[session:%d] Strict TLS client did not negotiate ALPN %q (got %q)", s.id, tds.ALPNStrict, proto)
+	}
+	s.clientConn = tlsConn
+	s.encryption = tds.EncryptionStrict
+	log.Printf("[session:%d] Client strict TLS established (TDS 8.0)", s.id)
+	return nil
+}
+
+// prefixConn devolve bytes já lidos da conexão antes de voltar a ler dela.
+type prefixConn struct {
+	net.Conn
+	prefix []byte
+}
+
+func (c *prefixConn) Read(b []byte) (int, error) {
+	if len(c.prefix) > 0 {
+		n := copy(b, c.prefix)
+		c.prefix = c.prefix[n:]
+		return n, nil
+	}
+	return c.Conn.Read(b)
+}
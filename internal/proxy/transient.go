@@ -0,0 +1,35 @@
+package proxy
+
+import (
+	"github.com/joao-brasil/poc-connection-pooling/internal/tds"
+)
+
+// ── Rejeições transitórias ──────────────────────────────────────────────
+//
+// Fila cheia, timeout de fila e backend indisponível são respondidos com o
+// número de erro configurado em proxy.transient_errors e uma sugestão de
+// retry baseada na espera recente da fila do bucket (ver tds.Transient).
+
+// errQueueFull constrói a rejeição por fila cheia.
+func (s *Session) errQueueFull(bucketID string) []byte {
+	return tds.ErrQueueFull(bucketID, s.transient(s.cfg.Proxy.TransientErrors.QueueFull, bucketID))
+}
+
+// errQueueTimeout constrói a rejeição por timeout de fila.
+func (s *Session) errQueueTimeout(bucketID string) []byte {
+	return tds.ErrQueueTimeout(bucketID, s.transient(s.cfg.Proxy.TransientErrors.QueueTimeout, bucketID))
+}
+
+// errBackendUnavailable constrói a rejeição por backend inacessível.
+func (s *Session) errBackendUnavailable(bucketID string) []byte {
+	return tds.ErrBackendUnavailable(bucketID, s.transient(s.cfg.Proxy.TransientErrors.BackendUnavailable, bucketID))
+}
+
+// transient combina o número de erro com a sugestão de retry do bucket.
+func (s *Session) transient(number uint32, bucketID string) tds.Transient {
+	t := tds.Transient{Number: number}
+	if s.dqueue != nil {
+		t.RetryAfter = s.dqueue.RetryAfter(bucketID)
+	}
+	return t
+}
@@ -31,6 +31,10 @@ type DistributedQueue struct {
 	mu     sync.Mutex
 	depths map[string]int
 
+	// waits é a média móvel (EWMA) do tempo de espera por bucket, base da
+	// sugestão de retry enviada a clientes recusados. Protegido por mu.
+	waits map[string]time.Duration
+
 	timeout      time.Duration // tempo máximo de espera por requisição
 	maxQueueSize int           // profundidade máxima da fila por bucket (0 = ilimitado)
 }
@@ -45,6 +49,7 @@ func NewDistributedQueue(rc *coordinator.RedisCoordinator, timeout time.Duration
 		coordinator:  rc,
 		semaphore:    coordinator.NewSemaphore(rc),
 		depths:       make(map[string]int),
+		waits:        make(map[string]time.Duration),
 		timeout:      timeout,
 		maxQueueSize: maxQueueSize,
 	}
@@ -64,6 +69,7 @@ func (dq *DistributedQueue) Acquire(ctx context.Context, bucketID string) error
 	// Caminho rápido: tentar aquisição não-bloqueante.
 	if err := dq.semaphore.TryAcquire(ctx, bucketID); err == nil {
 		metrics.ConnectionsTotal.WithLabelValues(bucketID, "acquired").Inc()
+		dq.recordWait(bucketID, 0)
 		return nil
 	}
 
@@ -93,6 +99,9 @@ func (dq *DistributedQueue) Acquire(ctx context.Context, bucketID string) error
 	start := time.Now()
 	err := dq.semaphore.Wait(ctx, bucketID, dq.timeout)
 	dur := time.Since(start)
+	if ctx.Err() == nil {
+		dq.recordWait(bucketID, dur)
+	}
 
 	if err != nil {
 		// Classificar o erro.
@@ -128,6 +137,19 @@ func (dq *DistributedQueue) Depth(bucketID string) int {
 	return dq.getDepth(bucketID)
 }
 
+// minRetryAfter é a menor sugestão de retry enviada a clientes recusados.
+const minRetryAfter = time.Second
+
+// RetryAfter sugere quanto um cliente recusado deve esperar antes de tentar
+// de novo: a média recente de espera na fila do bucket, entre minRetryAfter
+// e o timeout da fila.
+func (dq *DistributedQueue) RetryAfter(bucketID string) time.Duration {
+	dq.mu.Lock()
+	wait := dq.waits[bucketID]
+	dq.mu.Unlock()
+	return min(max(wait, minRetryAfter), dq.timeout)
+}
+
 // ── Tipos de Erro de Fila ─────────────────────────────────────────────
 
 // QueueErrorKind classifica o tipo de erro de fila.
@@ -200,6 +222,14 @@ func (dq *DistributedQueue) decrementDepth(bucketID string) {
 	metrics.QueueLength.WithLabelValues(bucketID).Set(float64(depth))
 }
 
+// recordWait atualiza a média móvel de espera do bucket (peso 0.2 para a
+// última amostra). Aquisições imediatas contam como espera zero.
+func (dq *DistributedQueue) recordWait(bucketID string, wait time.Duration) {
+	dq.mu.Lock()
+	defer dq.mu.Unlock()
+	dq.waits[bucketID] = (dq.waits[bucketID]*4 + wait) / 5
+}
+
 func (dq *DistributedQueue) getDepth(bucketID string) int {
 	dq.mu.Lock()
 	defer dq.mu.Unlock()
@@ -0,0 +1,90 @@
+package tds
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+)
+
+// ── SQL Batch (MS-TDS 2.2.6.7) ─────────────────────────────────────────
+//
+// Usado pelo proxy para falar diretamente com conexões que ele mesmo
+// autenticou (health check, reset de conexões do pool, rollback, replay de
+// estado e re-prepare de statements), sem driver SQL.
+
+// BuildSQLBatch monta o payload de um SQL_BATCH: ALL_HEADERS com o header
+// obrigatório de Transaction Descriptor (autocommit, 1 requisição
+// pendente) seguido do texto em UTF-16 LE.
+//
+//	Byte 0-3:   TotalLength de ALL_HEADERS (22)
+//	Byte 4-7:   HeaderLength (18)
+//	Byte 8-9:   HeaderType (2 = Transaction Descriptor)
+//	Byte 10-17: TransactionDescriptor (0 = sem transação)
+//	Byte 18-21: OutstandingRequestCount (1)
+//	Byte 22+:   SQLText (UTF-16 LE)
+func BuildSQLBatch(sql string) []byte {
+	return BuildTransactionBatch(sql, 0)
+}
+
+// BuildTransactionBatch monta um SQL_BATCH que executa dentro da transação
+// aberta identificada por descriptor (ver TransactionDescriptor). O servidor
+// recusa requisições com um descritor diferente do da transação corrente.
+func BuildTransactionBatch(sql string, descriptor uint64) []byte {
+	text := encodeUTF16LE(sql)
+	buf := make([]byte, 22, 22+len(text))
+	binary.LittleEndian.PutUint32(buf[0:4], 22)
+	binary.LittleEndian.PutUint32(buf[4:8], 18)
+	binary.LittleEndian.PutUint16(buf[8:10], 2)
+	binary.LittleEndian.PutUint64(buf[10:18], descriptor)
+	binary.LittleEndian.PutUint32(buf[18:22], 1)
+	return append(buf, text...)
+}
+
+// ExecBatch envia um SQL_BATCH e consome a resposta inteira. Com reset, o
+// primeiro pacote leva RESETCONNECTION e o servidor limpa o estado da
+// sessão antes de executar o texto. Um ERROR na resposta é devolvido como
+// erro.
+func ExecBatch(rw io.ReadWriter, sql string, packetSize int, reset bool) error {
+	_, err := execRequest(rw, PacketSQLBatch, BuildSQLBatch(sql), packetSize, reset)
+	return err
+}
+
+// ExecTransactionBatch é ExecBatch dentro da transação identificada por
+// descriptor.
+func ExecTransactionBatch(rw io.ReadWriter, sql string, descriptor uint64, packetSize int) error {
+	_, err := execRequest(rw, PacketSQLBatch, BuildTransactionBatch(sql, descriptor), packetSize, false)
+	return err
+}
+
+// ExecRPC envia um RPC_REQUEST montado pelo proxy e retorna os tokens da
+// resposta. Um ERROR na resposta é devolvido como erro.
+func ExecRPC(rw io.ReadWriter, payload []byte, packetSize int, reset bool) ([]Token, error) {
+	return execRequest(rw, PacketRPCRequest, payload, packetSize, reset)
+}
+
+// execRequest envia uma requisição do próprio proxy e consome a resposta.
+func execRequest(rw io.ReadWriter, pktType PacketType, payload []byte, packetSize int, reset bool) ([]Token, error) {
+	packets := BuildPackets(pktType, payload, packetSize)
+	if reset {
+		SetResetConnection(packets[0])
+	}
+	if err := WritePackets(rw, packets); err != nil {
+		return nil, fmt.Errorf("sending %s: %w", pktType, err)
+	}
+
+	_, resp, _, err := ReadMessage(rw)
+	if err != nil {
+		return nil, fmt.Errorf("reading %s response: %w", pktType, err)
+	}
+	tokens, err := ParseTokens(resp)
+	if err != nil {
+		return nil, fmt.Errorf("parsing %s response: %w", pktType, err)
+	}
+	for i := range tokens {
+		if tokens[i].IsError() {
+			number, message := tokens[i].ErrorInfo()
+			return tokens, fmt.Errorf("server error %d: %s", number, message)
+		}
+	}
+	return tokens, nil
+}
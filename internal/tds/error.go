@@ -2,6 +2,8 @@ package tds
 
 import (
 	"encoding/binary"
+	"strconv"
+	"time"
 )
 
 // ── TDS Error Token Builder ─────────────────────────────────────────────
@@ -16,6 +18,7 @@ import (
 const (
 	SeverityInfo    uint8 = 10
 	SeverityWarning uint8 = 11
+	SeverityLogin   uint8 = 14 // falhas de login (ex: 18456)
 	SeverityError   uint8 = 16
 	SeverityFatal   uint8 = 20
 )
@@ -136,6 +139,43 @@ func buildResponsePackets(payload []byte) []byte {
 	return result
 }
 
+// ── Erros transitórios ──────────────────────────────────────────────────
+//
+// Rejeições por falta de capacidade (fila cheia, timeout de fila, backend
+// indisponível) podem usar os números de erro transitório do SQL Server /
+// Azure SQL, que ADO.NET, JDBC e go-mssqldb repetem automaticamente. A
+// mensagem traz uma sugestão de espera, no formato do 40501 ("Retry the
+// request after N seconds."), para que o cliente recue em vez de insistir.
+
+// Números de erro transitório reconhecidos pela lógica de retry dos drivers.
+const (
+	ErrNumServiceBusy         uint32 = 40501 // The service is currently busy
+	ErrNumDatabaseUnavailable uint32 = 40613 // Database is not currently available
+	ErrNumNotEnoughResources  uint32 = 49918 // Not enough resources to process request
+)
+
+// Transient é o número de erro e a sugestão de espera de uma rejeição
+// transitória. Com Number zero é usado o número próprio do proxy.
+type Transient struct {
+	Number     uint32
+	RetryAfter time.Duration
+}
+
+func (t Transient) number(proxyNumber uint32) uint32 {
+	if t.Number != 0 {
+		return t.Number
+	}
+	return proxyNumber
+}
+
+func (t Transient) hint() string {
+	if t.RetryAfter <= 0 {
+		return " Try again later."
+	}
+	secs := int((t.RetryAfter + time.Second - 1) / time.Second)
+	return " Retry the request after " + strconv.Itoa(secs) + " seconds."
+}
+
 // ── Mensagens de erro pré-construídas ───────────────────────────────────
 
 // ErrPoolExhausted constrói uma resposta de erro para quando o connection pool está cheio.
@@ -159,11 +199,11 @@ func ErrRoutingFailed(database string) []byte {
 }
 
 // ErrBackendUnavailable constrói uma resposta de erro para quando o backend está inacessível.
-func ErrBackendUnavailable(bucketID string) []byte {
+func ErrBackendUnavailable(bucketID string, t Transient) []byte {
 	return BuildErrorResponse(
-		50003,
+		t.number(50003),
 		SeverityFatal,
-		"Backend SQL Server for bucket '"+bucketID+"' is unavailable.",
+		"Backend SQL Server for bucket '"+bucketID+"' is unavailable."+t.hint(),
 		"proxy",
 	)
 }
@@ -180,11 +220,57 @@ func ErrInternalError(message string) []byte {
 
 // ErrQueueTimeout constrói uma resposta de erro para quando uma requisição esperou na
 // fila por uma conexão mas o timeout expirou antes de uma ficar disponível.
-func ErrQueueTimeout(bucketID string) []byte {
+func ErrQueueTimeout(bucketID string, t Transient) []byte {
+	return BuildErrorResponse(
+		t.number(50004),
+		SeverityError,
+		"Connection queue timed out for bucket '"+bucketID+"'. All connections are in use and the wait period has expired."+t.hint(),
+		"proxy",
+	)
+}
+
+// ErrTransactionNotAllowed constrói uma resposta de erro para quando uma
+// requisição abriria uma transação que atravessa batches em um bucket no
+// modo statement pooling, em que a conexão backend é devolvida após cada batch.
+func ErrTransactionNotAllowed(bucketID string) []byte {
+	return BuildErrorResponse(
+		50006,
+		SeverityError,
+		"Multi-statement transactions are not allowed for bucket '"+bucketID+"' (statement pooling mode). Run the transaction in a single batch.",
+		"proxy",
+	)
+}
+
+// ErrQueryTimeout constrói uma resposta de erro para quando uma requisição
+// excedeu o query_timeout do bucket e foi cancelada pelo proxy no backend.
+func ErrQueryTimeout(bucketID string, timeout time.Duration) []byte {
 	return BuildErrorResponse(
-		50004,
+		50007,
 		SeverityError,
-		"Connection queue timed out for bucket '"+bucketID+"'. All connections are in use and the wait period has expired. Try again later.",
+		"Query exceeded the "+timeout.String()+" timeout for bucket '"+bucketID+"' and was cancelled by the proxy.",
+		"proxy",
+	)
+}
+
+// ErrIdleTimeout constrói uma resposta de erro para quando a sessão ficou
+// sem tráfego por mais que idle_timeout e será encerrada pelo proxy.
+func ErrIdleTimeout(timeout time.Duration) []byte {
+	return BuildErrorResponse(
+		50008,
+		SeverityFatal,
+		"Session was idle for more than "+timeout.String()+" and was closed by the proxy.",
+		"proxy",
+	)
+}
+
+// ErrIdleInTransactionTimeout constrói uma resposta de erro para quando a
+// sessão ficou ociosa com uma transação aberta por mais que
+// idle_in_transaction_timeout; a transação é desfeita e a sessão encerrada.
+func ErrIdleInTransactionTimeout(timeout time.Duration) []byte {
+	return BuildErrorResponse(
+		50009,
+		SeverityFatal,
+		"Session was idle in an open transaction for more than "+timeout.String()+". The transaction was rolled back and the session was closed by the proxy.",
 		"proxy",
 	)
 }
@@ -192,11 +278,34 @@ func ErrQueueTimeout(bucketID string) []byte {
 // ErrQueueFull constrói uma resposta de erro para quando a fila de conexões
 // atingiu seu tamanho máximo (circuit breaker). A requisição é rejeitada
 // imediatamente sem esperar.
-func ErrQueueFull(bucketID string) []byte {
+func ErrQueueFull(bucketID string, t Transient) []byte {
+	return BuildErrorResponse(
+		t.number(50005),
+		SeverityError,
+		"Connection queue is full for bucket '"+bucketID+"'. Too many requests are already waiting."+t.hint(),
+		"proxy",
+	)
+}
+
+// ErrLoginFailed constrói a resposta a um login recusado pela autenticação
+// do proxy, com o mesmo número e texto do SQL Server (18456), que drivers
+// tratam como falha de credenciais e não como erro transitório.
+func ErrLoginFailed(user string) []byte {
+	return BuildErrorResponse(
+		18456,
+		SeverityLogin,
+		"Login failed for user '"+user+"'.",
+		"proxy",
+	)
+}
+
+// ErrUnknownInstance constrói uma resposta de erro para quando o nome de
+// instância pedido no Pre-Login não corresponde a nenhum bucket.
+func ErrUnknownInstance(instance string) []byte {
 	return BuildErrorResponse(
-		50005,
+		50010,
 		SeverityError,
-		"Connection queue is full for bucket '"+bucketID+"'. Too many requests are already waiting. Try again later.",
+		"No bucket configured for instance '"+instance+"'. Check the instance name in the connection string.",
 		"proxy",
 	)
 }
@@ -0,0 +1,163 @@
+package tds
+
+import (
+	"encoding/binary"
+	"fmt"
+	"strings"
+)
+
+// ── Feature Extensions (MS-TDS 2.2.6.4 / 2.2.7.11) ──────────────────────
+//
+// A partir do TDS 7.4 o Login7 pode trazer um bloco FeatureExt com recursos
+// opcionais pedidos pelo cliente (UTF-8, session recovery, Always
+// Encrypted, autenticação federada...). O servidor confirma os que aceitou
+// com um token FEATUREEXTACK na resposta de login. Os dois usam o mesmo
+// formato:
+//
+//	FeatureId      (BYTE)   0xFF termina a lista
+//	FeatureDataLen (DWORD)
+//	FeatureData    (FeatureDataLen bytes)
+//
+// No Login7, OptionFlags3.fExtension indica o bloco; ibExtension aponta
+// para um DWORD com o offset do FeatureExt dentro do Login7.
+
+// FeatureID identifica uma feature extension.
+type FeatureID byte
+
+// Feature extensions conhecidas.
+const (
+	FeatureSessionRecovery    FeatureID = 0x01
+	FeatureFedAuth            FeatureID = 0x02
+	FeatureColumnEncryption   FeatureID = 0x04
+	FeatureGlobalTransactions FeatureID = 0x05
+	FeatureAzureSQLSupport    FeatureID = 0x08
+	FeatureDataClassification FeatureID = 0x09
+	FeatureUTF8Support        FeatureID = 0x0A
+	FeatureAzureSQLDNSCaching FeatureID = 0x0B
+
+	featureTerminator byte = 0xFF
+)
+
+// Bibliotecas de autenticação federada (FEDAUTH, bFedAuthLibrary).
+const (
+	FedAuthLibrarySecurityToken byte = 0x01 // token no próprio Login7
+	FedAuthLibraryADAL          byte = 0x02 // token pedido via FEDAUTHINFO
+)
+
+// login7Extension é OptionFlags3.fExtension.
+const login7Extension byte = 0x10
+
+// String retorna o nome da feature como na especificação.
+func (id FeatureID) String() string {
+	switch id {
+	case FeatureSessionRecovery:
+		return "SESSIONRECOVERY"
+	case FeatureFedAuth:
+		return "FEDAUTH"
+	case FeatureColumnEncryption:
+		return "COLUMNENCRYPTION"
+	case FeatureGlobalTransactions:
+		return "GLOBALTRANSACTIONS"
+	case FeatureAzureSQLSupport:
+		return "AZURESQLSUPPORT"
+	case FeatureDataClassification:
+		return "DATACLASSIFICATION"
+	case FeatureUTF8Support:
+		return "UTF8_SUPPORT"
+	case FeatureAzureSQLDNSCaching:
+		return "AZURESQLDNSCACHING"
+	}
+	return fmt.Sprintf("0x%02X", byte(id))
+}
+
+// Feature é uma feature extension com seus dados.
+type Feature struct {
+	ID   FeatureID
+	Data []byte
+}
+
+// FeatureSet é a lista de features de um Login7 ou de um FEATUREEXTACK.
+type FeatureSet []Feature
+
+// Has indica se a feature está presente.
+func (fs FeatureSet) Has(id FeatureID) bool {
+	_, ok := fs.Get(id)
+	return ok
+}
+
+// Get retorna os dados da feature.
+func (fs FeatureSet) Get(id FeatureID) ([]byte, bool) {
+	for _, f := range fs {
+		if f.ID == id {
+			return f.Data, true
+		}
+	}
+	return nil, false
+}
+
+// FedAuthLibrary retorna a biblioteca de autenticação federada pedida
+// (bits 1-7 do primeiro byte de FEDAUTH).
+func (fs FeatureSet) FedAuthLibrary() (byte, bool) {
+	data, ok := fs.Get(FeatureFedAuth)
+	if !ok || len(data) == 0 {
+		return 0, false
+	}
+	return data[0] >> 1, true
+}
+
+// String lista os nomes das features (ex: "SESSIONRECOVERY,UTF8_SUPPORT").
+func (fs FeatureSet) String() string {
+	names := make([]string, len(fs))
+	for i, f := range fs {
+		names[i] = f.ID.String()
+	}
+	return strings.Join(names, ",")
+}
+
+// parseFeatures lê uma lista de features até o terminador 0xFF.
+func parseFeatures(data []byte) (FeatureSet, error) {
+	var fs FeatureSet
+	for pos := 0; pos < len(data); {
+		id := data[pos]
+		if id == featureTerminator {
+			return fs, nil
+		}
+		if pos+5 > len(data) {
+			return fs, fmt.Errorf("feature 0x%02X: truncated header", id)
+		}
+		n := int(binary.LittleEndian.Uint32(data[pos+1 : pos+5]))
+		pos += 5
+		if n > len(data)-pos {
+			return fs, fmt.Errorf("feature 0x%02X: data length %d overflows block", id, n)
+		}
+		fs = append(fs, Feature{ID: FeatureID(id), Data: data[pos : pos+n]})
+		pos += n
+	}
+	return fs, fmt.Errorf("feature list without terminator")
+}
+
+// readLogin7Features lê o bloco FeatureExt de um Login7, se houver.
+func readLogin7Features(payload []byte) (FeatureSet, error) {
+	if payload[27]&login7Extension == 0 {
+		return nil, nil
+	}
+	ib := int(binary.LittleEndian.Uint16(payload[56:58]))
+	cb := int(binary.LittleEndian.Uint16(payload[58:60]))
+	if cb < 4 || ib+4 > len(payload) {
+		return nil, fmt.Errorf("extension pointer at offset %d overflows payload (%d bytes)", ib, len(payload))
+	}
+	offset := int(binary.LittleEndian.Uint32(payload[ib : ib+4]))
+	if offset >= len(payload) {
+		return nil, fmt.Errorf("featureext offset %d overflows payload (%d bytes)", offset, len(payload))
+	}
+	return parseFeatures(payload[offset:])
+}
+
+// FeatureAcks retorna as features confirmadas em um token FEATUREEXTACK.
+func (t *Token) FeatureAcks() (FeatureSet, bool) {
+	if t.Type != tokenFeatureExtAck {
+		return nil, false
+	}
+	fs, err := parseFeatures(t.Data)
+	return fs, err == nil
+}
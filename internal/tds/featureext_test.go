@@ -0,0 +1,119 @@
+package tds
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+)
+
+func TestParseFeatures(t *testing.T) {
+	tests := []struct {
+		name    string
+		data    []byte
+		want    string
+		wantErr bool
+	}{
+		{"terminator only", []byte{featureTerminator}, "", false},
+		{"two features", testFeatures, "SESSIONRECOVERY,UTF8_SUPPORT", false},
+		{"unknown id", []byte{0x42, 2, 0, 0, 0, 1, 2, featureTerminator}, "0x42", false},
+		{"data after terminator", append(append([]byte(nil), testFeatures...), 1, 2, 3), "SESSIONRECOVERY,UTF8_SUPPORT", false},
+		{"empty", nil, "", true},
+		{"no terminator", testFeatures[:len(testFeatures)-1], "SESSIONRECOVERY,UTF8_SUPPORT", true},
+		{"truncated header", []byte{byte(FeatureFedAuth), 1, 0}, "", true},
+		{"length overflows block", []byte{byte(FeatureFedAuth), 9, 0, 0, 0, 1, featureTerminator}, "", true},
+	}
+	for _, tt := range tests {
+		fs, err := parseFeatures(tt.data)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("%s: err = %v, want error %v", tt.name, err, tt.wantErr)
+		}
+		if fs.String() != tt.want {
+			t.Errorf("%s: features = %q, want %q", tt.name, fs, tt.want)
+		}
+	}
+}
+
+func TestFeatureSet(t *testing.T) {
+	fs, err := parseFeatures([]byte{
+		byte(FeatureFedAuth), 2, 0, 0, 0, FedAuthLibraryADAL<<1 | 1, 0x01,
+		byte(FeatureUTF8Support), 1, 0, 0, 0, 0x01,
+		featureTerminator,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !fs.Has(FeatureFedAuth) || fs.Has(FeatureColumnEncryption) {
+		t.Errorf("Has: %s", fs)
+	}
+	if lib, ok := fs.FedAuthLibrary(); !ok || lib != FedAuthLibraryADAL {
+		t.Errorf("FedAuthLibrary = %d, %v", lib, ok)
+	}
+	if data, ok := fs.Get(FeatureUTF8Support); !ok || !bytes.Equal(data, []byte{0x01}) {
+		t.Errorf("Get(UTF8_SUPPORT) = %x, %v", data, ok)
+	}
+	if _, ok := FeatureSet(nil).FedAuthLibrary(); ok {
+		t.Error("FedAuthLibrary reported without FEDAUTH")
+	}
+}
+
+func TestReadLogin7Features(t *testing.T) {
+	for _, extFirst := range []bool{false, true} {
+		payload := testLogin7{user: "u", database: "db", features: testFeatures, extFirst: extFirst}.build()
+		fs, err := readLogin7Features(payload)
+		if err != nil || fs.String() != "SESSIONRECOVERY,UTF8_SUPPORT" {
+			t.Errorf("extFirst=%v: features = %s, %v", extFirst, fs, err)
+		}
+	}
+
+	if fs, err := readLogin7Features(testLogin7{user: "u"}.build()); fs != nil || err != nil {
+		t.Errorf("without fExtension: %s, %v", fs, err)
+	}
+
+	payload := testLogin7{user: "u", features: testFeatures}.build()
+	ib := int(binary.LittleEndian.Uint16(payload[56:58]))
+
+	badPointer := append([]byte(nil), payload...)
+	binary.LittleEndian.PutUint16(badPointer[56:58], uint16(len(payload)))
+	badOffset := append([]byte(nil), payload...)
+	binary.LittleEndian.PutUint32(badOffset[ib:], uint32(len(payload)+10))
+	shortCb := append([]byte(nil), payload...)
+	binary.LittleEndian.PutUint16(shortCb[58:60], 2)
+
+	for name, p := range map[string][]byte{"pointer": badPointer, "offset": badOffset, "cbExtension": shortCb} {
+		if _, err := readLogin7Features(p); err == nil {
+			t.Errorf("bad %s accepted", name)
+		}
+	}
+}
+
+// TestFeatureExtAckSplit confirma que o FEATUREEXTACK, que não tem prefixo
+// de tamanho, é lido corretamente quando chega dividido entre pacotes.
+func TestFeatureExtAckSplit(t *testing.T) {
+	b := &streamBuilder{}
+	b.u8(tokenFeatureExtAck).raw(testFeatures)
+	b.done(tokenDone, 0)
+	payload := b.Bytes()
+
+	for cut := 1; cut < len(payload); cut++ {
+		p := NewResponseParser()
+		first, err := p.Feed(payload[:cut])
+		if err != nil {
+			t.Fatalf("cut %d: %v", cut, err)
+		}
+		rest, err := p.Feed(payload[cut:])
+		if err != nil {
+			t.Fatalf("cut %d: %v", cut, err)
+		}
+		tokens := append(first, rest...)
+		if len(tokens) != 2 {
+			t.Fatalf("cut %d: got %d tokens", cut, len(tokens))
+		}
+		if fs, ok := tokens[0].FeatureAcks(); !ok || fs.String() != "SESSIONRECOVERY,UTF8_SUPPORT" {
+			t.Errorf("cut %d: FeatureAcks = %s, %v", cut, fs, ok)
+		}
+	}
+
+	if _, ok := (&Token{Type: tokenDone}).FeatureAcks(); ok {
+		t.Error("FeatureAcks reported for a DONE token")
+	}
+}
@@ -13,6 +13,9 @@ import (
 //   - Nome do banco   (para roteamento ao bucket correto)
 //   - Username        (para logging/métricas)
 //   - Nome do servidor (para roteamento alternativo)
+//   - Senha e idioma  (para a chave de identidade do transaction pooling)
+//   - Intenção de leitura (TypeFlags, para rotear a réplicas)
+//   - Feature extensions (FeatureExt, ver featureext.go)
 //
 // Login7 layout (fixed header at offset 0 within the payload):
 //
@@ -65,8 +68,28 @@ type Login7Info struct {
 	// Database é o nome do banco inicial — usado para roteamento.
 	Database string
 
+	// Password é a senha desofuscada. Nunca deve ir para logs; o proxy a usa
+	// apenas para agrupar conexões backend autenticadas com o mesmo login.
+	Password string
+
+	// Language é o idioma inicial solicitado (vazio = padrão do login).
+	Language string
+
+	// IntegratedSecurity indica autenticação SSPI/Windows (OptionFlags2.fIntSecurity).
+	IntegratedSecurity bool
+
+	// ChangePassword indica que o cliente pede a troca da senha no login
+	// (OptionFlags3.fChangePassword ou campo ChangePassword preenchido).
+	ChangePassword bool
+
+	// ReadOnlyIntent indica ApplicationIntent=ReadOnly (TypeFlags.fReadOnlyIntent).
+	ReadOnlyIntent bool
+
 	// ClientInterfaceName é o nome da biblioteca cliente (ex: "go-mssqldb").
 	ClientInterfaceName string
+
+	// Features são as feature extensions pedidas pelo cliente.
+	Features FeatureSet
 }
 
 // ParseLogin7 faz o parse de um payload Login7 (os bytes após o header TDS)
@@ -122,7 +145,19 @@ func ParseLogin7(payload []byte) (*Login7Info, error) {
 		return nil, fmt.Errorf("login7 username: %w", err)
 	}
 
-	// Password no offset 44 — não precisamos decodificá-la (está ofuscada com XOR).
+	info.IntegratedSecurity = payload[25]&login7IntSecurity != 0
+	info.ChangePassword = payload[27]&login7ChangePassword != 0
+	if login7HeaderSize(payload) >= login7HeaderSize72 && len(payload) >= login7HeaderSize72 &&
+		binary.LittleEndian.Uint16(payload[login7ChangePasswordPos+2:login7ChangePasswordPos+4]) > 0 {
+		info.ChangePassword = true
+	}
+	info.ReadOnlyIntent = payload[26]&login7ReadOnlyIntent != 0
+
+	// Password no offset 44 está ofuscada (nibbles trocados + XOR 0xA5).
+	info.Password, err = readPassword(payload)
+	if err != nil {
+		return nil, fmt.Errorf("login7 password: %w", err)
+	}
 
 	info.AppName, err = readField(48)
 	if err != nil {
@@ -139,14 +174,220 @@ func ParseLogin7(payload []byte) (*Login7Info, error) {
 		return nil, fmt.Errorf("login7 client interface name: %w", err)
 	}
 
+	info.Language, err = readField(64)
+	if err != nil {
+		return nil, fmt.Errorf("login7 language: %w", err)
+	}
+
 	info.Database, err = readField(68)
 	if err != nil {
 		return nil, fmt.Errorf("login7 database: %w", err)
 	}
 
+	info.Features, err = readLogin7Features(payload)
+	if err != nil {
+		return nil, fmt.Errorf("login7 featureext: %w", err)
+	}
+
 	return info, nil
 }
 
+// ── Constantes de Login7 ───────────────────────────────────────────────
+
+// Versão TDS 7.4 e tamanho de pacote assumido quando o LOGINACK não traz
+// um ENVCHANGE de packet size.
+const (
+	TDSVersion74      uint32 = 0x74000004
+	DefaultPacketSize        = 4096
+)
+
+// login7FixedSize é o header fixo do Login7 a partir do TDS 7.2: após os
+// pares de 36-71 vêm ClientID (6 bytes), SSPI, AtchDBFile, ChangePassword e
+// cbSSPILong (MS-TDS 2.2.6.4).
+const login7FixedSize = 94
+
+// login7ReadOnlyIntent é TypeFlags.fReadOnlyIntent (ApplicationIntent=ReadOnly).
+const login7ReadOnlyIntent byte = 0x20
+
+// Flags de autenticação do cliente que o proxy inspeciona e limpa.
+const (
+	login7IntSecurity    byte = 0x80 // OptionFlags2.fIntSecurity
+	login7ChangePassword byte = 0x01 // OptionFlags3.fChangePassword
+)
+
+// ── Troca de credenciais ────────────────────────────────────────────────
+//
+// Com autenticação no proxy, o Login7 do cliente segue para o backend com
+// UserName e Password trocados pelas credenciais de serviço do bucket; o
+// restante (flags, idioma, database, FeatureExt) é preservado. Cada campo
+// é substituído no lugar e os offsets dos dados posteriores são deslocados.
+// SSPI, AtchDBFile e ChangePassword são esvaziados e os flags de segurança
+// integrada e troca de senha limpos: o backend só deve ver o login de
+// serviço, nunca um pedido do cliente em nome dele.
+
+// login7OffsetFields são as posições dos pares (offset, length) do Login7:
+// 36-68 (HostName a Database) e, no header estendido, SSPI, AtchDBFile e
+// ChangePassword.
+var login7OffsetFields = []int{36, 40, 44, 48, 52, 56, 60, 64, 68, 78, 82, 86}
+
+// Tamanhos do header fixo do Login7: até o TDS 7.1 termina em AtchDBFile
+// (86 bytes); a partir do TDS 7.2 inclui ChangePassword e cbSSPILong.
+const (
+	login7HeaderSize71 = 86
+	login7HeaderSize72 = login7FixedSize
+	tdsVersion72       = 0x72000000
+)
+
+// Posições dos pares do header estendido e de cbSSPILong.
+const (
+	login7SSPIPos           = 78
+	login7AtchDBFilePos     = 82
+	login7ChangePasswordPos = 86
+	login7SSPILongPos       = 90
+)
+
+// login7HeaderSize retorna o tamanho do header fixo, onde começam os dados
+// variáveis. Vem do layout da versão, não dos offsets: clientes podem
+// enviar ib = 0 para campos vazios.
+func login7HeaderSize(payload []byte) int {
+	if binary.LittleEndian.Uint32(payload[4:8]) >= tdsVersion72 {
+		return login7HeaderSize72
+	}
+	return login7HeaderSize71
+}
+
+// SetLogin7Credentials retorna uma cópia do payload Login7 com UserName e
+// Password substituídos e sem SSPI, AtchDBFile nem ChangePassword.
+func SetLogin7Credentials(payload []byte, user, password string) ([]byte, error) {
+	if _, err := ParseLogin7(payload); err != nil {
+		return nil, err
+	}
+	headerEnd := login7HeaderSize(payload)
+	if len(payload) < headerEnd {
+		return nil, fmt.Errorf("login7 payload too short: %d bytes (need >= %d)", len(payload), headerEnd)
+	}
+
+	type field struct {
+		pos   int
+		unit  int // bytes por unidade do campo de tamanho
+		value []byte
+	}
+	fields := []field{
+		{40, 2, encodeUTF16LE(user)},
+		{44, 2, obfuscatePassword(password)},
+		{login7SSPIPos, 1, nil},
+		{login7AtchDBFilePos, 2, nil},
+	}
+	if headerEnd >= login7HeaderSize72 {
+		fields = append(fields, field{login7ChangePasswordPos, 2, nil})
+	}
+
+	out := append([]byte(nil), payload...)
+	for _, f := range fields {
+		var err error
+		if out, err = spliceLogin7Field(out, headerEnd, f.pos, f.unit, f.value); err != nil {
+			return nil, err
+		}
+	}
+	if headerEnd >= login7HeaderSize72 {
+		binary.LittleEndian.PutUint32(out[login7SSPILongPos:login7SSPILongPos+4], 0)
+	}
+	out[25] &^= login7IntSecurity
+	out[27] &^= login7ChangePassword
+
+	if len(out) > 0xFFFF {
+		return nil, fmt.Errorf("login7 payload too large after credential change: %d bytes", len(out))
+	}
+	binary.LittleEndian.PutUint32(out[0:4], uint32(len(out)))
+	return out, nil
+}
+
+// spliceLogin7Field troca os dados do campo descrito em pos por value e
+// desloca os offsets de todos os dados que vinham depois dele. unit é o
+// número de bytes por unidade do tamanho (2 para texto, 1 para SSPI). Um
+// campo vazio cujo offset não aponta para a área de dados recebe o valor
+// logo após o header fixo.
+func spliceLogin7Field(payload []byte, headerEnd, pos, unit int, value []byte) ([]byte, error) {
+	ib := int(binary.LittleEndian.Uint16(payload[pos : pos+2]))
+	size := int(binary.LittleEndian.Uint16(payload[pos+2:pos+4])) * unit
+	if pos == login7SSPIPos && size == 0xFFFF && headerEnd >= login7HeaderSize72 {
+		// cbSSPI = 0xFFFF: o tamanho real está em cbSSPILong.
+		size = int(binary.LittleEndian.Uint32(payload[login7SSPILongPos : login7SSPILongPos+4]))
+	}
+	if size == 0 && (ib < headerEnd || ib > len(payload)) {
+		ib = headerEnd
+	}
+	end := ib + size
+	if ib < headerEnd || end > len(payload) {
+		return nil, fmt.Errorf("login7 field at %d (offset %d, %d bytes) is outside the data area", pos, ib, size)
+	}
+	delta := len(value) - size
+
+	// O DWORD de FeatureExt guarda um offset absoluto que também se desloca.
+	featureExtPtr := -1
+	if payload[27]&login7Extension != 0 {
+		featureExtPtr = int(binary.LittleEndian.Uint16(payload[56:58]))
+	}
+
+	out := make([]byte, 0, len(payload)+delta)
+	out = append(out, payload[:ib]...)
+	out = append(out, value...)
+	out = append(out, payload[end:]...)
+
+	for _, p := range login7OffsetFields {
+		if p == pos || p+4 > headerEnd {
+			continue
+		}
+		if off := int(binary.LittleEndian.Uint16(out[p : p+2])); off >= end && off >= headerEnd {
+			binary.LittleEndian.PutUint16(out[p:p+2], uint16(off+delta))
+		}
+	}
+	binary.LittleEndian.PutUint16(out[pos:pos+2], uint16(ib))
+	binary.LittleEndian.PutUint16(out[pos+2:pos+4], uint16(len(value)/unit))
+
+	if featureExtPtr >= headerEnd {
+		if featureExtPtr >= end {
+			featureExtPtr += delta
+		}
+		if featureExtPtr+4 <= len(out) {
+			if off := int(binary.LittleEndian.Uint32(out[featureExtPtr:])); off >= end {
+				binary.LittleEndian.PutUint32(out[featureExtPtr:], uint32(off+delta))
+			}
+		}
+	}
+	return out, nil
+}
+
+// obfuscatePassword aplica a ofuscação de senha do Login7: cada byte UTF-16
+// tem os nibbles trocados e depois é combinado com XOR 0xA5.
+func obfuscatePassword(password string) []byte {
+	buf := encodeUTF16LE(password)
+	for i, b := range buf {
+		buf[i] = (b<<4 | b>>4) ^ 0xA5
+	}
+	return buf
+}
+
+// readPassword lê e desofusca o campo de senha do Login7 (MS-TDS 2.2.6.4):
+// cada byte teve os nibbles trocados e depois foi combinado com XOR 0xA5.
+func readPassword(payload []byte) (string, error) {
+	ib := int(binary.LittleEndian.Uint16(payload[44:46]))
+	cch := int(binary.LittleEndian.Uint16(payload[46:48]))
+	if cch == 0 {
+		return "", nil
+	}
+	if ib+cch*2 > len(payload) {
+		return "", fmt.Errorf("field at offset %d, len %d chars overflows payload (%d bytes)",
+			ib, cch, len(payload))
+	}
+	buf := make([]byte, cch*2)
+	for i, b := range payload[ib : ib+cch*2] {
+		b ^= 0xA5
+		buf[i] = b<<4 | b>>4
+	}
+	return decodeUTF16LE(buf)
+}
+
 // decodeUTF16LE decodifica um slice de bytes UTF-16 little-endian para uma string Go.
 func decodeUTF16LE(b []byte) (string, error) {
 	if len(b)%2 != 0 {
@@ -0,0 +1,254 @@
+package tds
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+)
+
+// testLogin7 descreve um Login7 montado pelos testes, com controle do
+// layout (versão, offsets zerados, FeatureExt).
+type testLogin7 struct {
+	version  uint32
+	host     string
+	user     string
+	password string
+	app      string
+	server   string
+	iface    string
+	language string
+	database string
+
+	// zeroEmpty grava ib = 0 nos campos vazios, como alguns drivers.
+	zeroEmpty bool
+
+	// features é o bloco FeatureExt (com terminador); extFirst põe o DWORD
+	// de ibExtension antes dos textos em vez de depois deles.
+	features []byte
+	extFirst bool
+
+	// changePassword e atchDBFile preenchem os campos do header estendido.
+	changePassword string
+	atchDBFile     string
+	sspi           []byte
+}
+
+func (l testLogin7) build() []byte {
+	version := l.version
+	if version == 0 {
+		version = TDSVersion74
+	}
+	headerSize := login7HeaderSize72
+	if version < tdsVersion72 {
+		headerSize = login7HeaderSize71
+	}
+	buf := make([]byte, headerSize)
+	binary.LittleEndian.PutUint32(buf[4:8], version)
+	binary.LittleEndian.PutUint32(buf[8:12], 4096)
+
+	put := func(pos int, data []byte, length int) {
+		ib := len(buf)
+		if length == 0 && l.zeroEmpty {
+			ib = 0
+		}
+		binary.LittleEndian.PutUint16(buf[pos:pos+2], uint16(ib))
+		binary.LittleEndian.PutUint16(buf[pos+2:pos+4], uint16(length))
+		buf = append(buf, data...)
+	}
+	text := func(pos int, s string) {
+		data := encodeUTF16LE(s)
+		put(pos, data, len(data)/2)
+	}
+
+	var extPos int
+	ext := func() {
+		if l.features == nil {
+			put(56, nil, 0)
+			return
+		}
+		buf[27] |= login7Extension
+		extPos = len(buf)
+		put(56, make([]byte, 4), 4)
+	}
+
+	if l.extFirst {
+		ext()
+	}
+	text(36, l.host)
+	text(40, l.user)
+	pw := obfuscatePassword(l.password)
+	put(44, pw, len(pw)/2)
+	text(48, l.app)
+	text(52, l.server)
+	if !l.extFirst {
+		ext()
+	}
+	text(60, l.iface)
+	text(64, l.language)
+	text(68, l.database)
+	put(78, l.sspi, len(l.sspi))
+	text(82, l.atchDBFile)
+	if headerSize >= login7HeaderSize72 {
+		text(86, l.changePassword)
+	}
+	if l.features != nil {
+		binary.LittleEndian.PutUint32(buf[extPos:], uint32(len(buf)))
+		buf = append(buf, l.features...)
+	}
+	binary.LittleEndian.PutUint32(buf[0:4], uint32(len(buf)))
+	return buf
+}
+
+var testFeatures = []byte{
+	byte(FeatureSessionRecovery), 0, 0, 0, 0,
+	byte(FeatureUTF8Support), 1, 0, 0, 0, 0x01,
+	featureTerminator,
+}
+
+func TestParseLogin7(t *testing.T) {
+	payload := testLogin7{
+		host: "app-01", user: "tenant", password: "s3cr3t!", app: "billing",
+		server: "proxy", iface: "go-mssqldb", language: "us_english", database: "tenant_db",
+		features: testFeatures,
+	}.build()
+
+	info, err := ParseLogin7(payload)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.HostName != "app-01" || info.UserName != "tenant" || info.Password != "s3cr3t!" ||
+		info.AppName != "billing" || info.ServerName != "proxy" || info.ClientInterfaceName != "go-mssqldb" ||
+		info.Language != "us_english" || info.Database != "tenant_db" {
+		t.Errorf("ParseLogin7 = %+v", info)
+	}
+	if info.Features.String() != "SESSIONRECOVERY,UTF8_SUPPORT" {
+		t.Errorf("features = %s", info.Features)
+	}
+
+	for _, n := range []int{0, 10, 71, 80} {
+		if _, err := ParseLogin7(payload[:n]); err == nil {
+			t.Errorf("ParseLogin7 accepted %d bytes", n)
+		}
+	}
+	overflow := append([]byte(nil), payload...)
+	binary.LittleEndian.PutUint16(overflow[70:72], 0x7FFF) // cchDatabase
+	if _, err := ParseLogin7(overflow); err == nil {
+		t.Error("ParseLogin7 accepted a field past the end of the payload")
+	}
+}
+
+func TestParseLogin7ChangePassword(t *testing.T) {
+	payload := testLogin7{user: "tenant", password: "old", changePassword: "new"}.build()
+	info, err := ParseLogin7(payload)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !info.ChangePassword {
+		t.Error("ChangePassword not reported for a filled ChangePassword field")
+	}
+
+	payload = testLogin7{user: "tenant", password: "old"}.build()
+	payload[27] |= login7ChangePassword
+	if info, err = ParseLogin7(payload); err != nil || !info.ChangePassword {
+		t.Errorf("ChangePassword not reported for fChangePassword: %v", err)
+	}
+}
+
+func TestSetLogin7Credentials(t *testing.T) {
+	base := testLogin7{
+		host: "app-01", user: "tenant", password: "tenant-pw", app: "billing",
+		server: "proxy", iface: "go-mssqldb", language: "us_english", database: "tenant_db",
+	}
+	withFeatures := base
+	withFeatures.features = testFeatures
+	extFirst := withFeatures
+	extFirst.extFirst = true
+	emptyZero := testLogin7{user: "", password: "", database: "tenant_db", zeroEmpty: true}
+	emptyZeroFeatures := emptyZero
+	emptyZeroFeatures.features = testFeatures
+	tds71 := base
+	tds71.version = 0x71000001
+	extended := withFeatures
+	extended.changePassword = "new-tenant-pw"
+	extended.atchDBFile = `C:\data\tenant.mdf`
+	extended.sspi = []byte{0x4E, 0x54, 0x4C, 0x4D, 0x53, 0x53, 0x50, 0x00, 0x01}
+	extended71 := extended
+	extended71.version = 0x71000001
+	extended71.features = nil
+
+	tests := []struct {
+		name  string
+		login testLogin7
+		user  string
+		pw    string
+	}{
+		{"longer credentials", base, "svc_bucket_001_service_login", "a much longer service password"},
+		{"shorter credentials", base, "s", "p"},
+		{"empty credentials", base, "", ""},
+		{"featureext after the strings", withFeatures, "svc", "service-pw"},
+		{"featureext before the strings", extFirst, "svc_longer_name", "pw"},
+		{"empty fields with ib 0", emptyZero, "svc", "service-pw"},
+		{"empty fields with ib 0 and featureext", emptyZeroFeatures, "svc", "service-pw"},
+		{"tds 7.1 header", tds71, "svc", "service-pw"},
+		{"sspi, atchdbfile and changepassword", extended, "svc", "service-pw"},
+		{"tds 7.1 header with sspi and atchdbfile", extended71, "svc", "service-pw"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			payload := tt.login.build()
+			before, err := ParseLogin7(payload)
+			if err != nil {
+				t.Fatal(err)
+			}
+			orig := append([]byte(nil), payload...)
+
+			out, err := SetLogin7Credentials(payload, tt.user, tt.pw)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(payload, orig) {
+				t.Error("input payload was modified")
+			}
+			if got := int(binary.LittleEndian.Uint32(out[0:4])); got != len(out) {
+				t.Errorf("length field = %d, payload = %d bytes", got, len(out))
+			}
+			after, err := ParseLogin7(out)
+			if err != nil {
+				t.Fatalf("rewritten payload does not parse: %v", err)
+			}
+			if after.UserName != tt.user || after.Password != tt.pw {
+				t.Errorf("credentials = %q/%q, want %q/%q", after.UserName, after.Password, tt.user, tt.pw)
+			}
+			before.UserName, before.Password = after.UserName, after.Password
+			if before.HostName != after.HostName || before.AppName != after.AppName ||
+				before.ServerName != after.ServerName || before.ClientInterfaceName != after.ClientInterfaceName ||
+				before.Language != after.Language || before.Database != after.Database ||
+				before.Features.String() != after.Features.String() {
+				t.Errorf("other fields changed:\n before %+v\n after  %+v", before, after)
+			}
+			for i, f := range before.Features {
+				if !bytes.Equal(f.Data, after.Features[i].Data) {
+					t.Errorf("feature %s data changed", f.ID)
+				}
+			}
+			if bytes.Contains(out, encodeUTF16LE("tenant-pw")) || bytes.Contains(out, obfuscatePassword("tenant-pw")) {
+				t.Error("client password still present in the rewritten payload")
+			}
+			if after.ChangePassword || after.IntegratedSecurity || out[27]&login7ChangePassword != 0 {
+				t.Error("change password or integrated security flags left set")
+			}
+			headerEnd := login7HeaderSize(out)
+			for _, pos := range []int{login7SSPIPos, login7AtchDBFilePos, login7ChangePasswordPos} {
+				if pos+4 <= headerEnd && binary.LittleEndian.Uint16(out[pos+2:pos+4]) != 0 {
+					t.Errorf("field at %d not emptied", pos)
+				}
+			}
+			if headerEnd >= login7HeaderSize72 && binary.LittleEndian.Uint32(out[login7SSPILongPos:]) != 0 {
+				t.Error("cbSSPILong not cleared")
+			}
+			if tt.login.sspi != nil && bytes.Contains(out, tt.login.sspi) {
+				t.Error("SSPI blob still present in the rewritten payload")
+			}
+		})
+	}
+}
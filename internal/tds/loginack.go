@@ -0,0 +1,222 @@
+package tds
+
+import (
+	"encoding/binary"
+	"fmt"
+	"strconv"
+)
+
+// ── Resposta de Login (MS-TDS 2.2.7.14 / 2.2.7.9 / 2.2.7.8) ────────────
+//
+// Após o Login7, o servidor responde com um token stream contendo
+// LOGINACK (sucesso) ou ERROR (falha), além de ENVCHANGEs com o estado
+// inicial da sessão (database, idioma, packet size) e um DONE final.
+// O proxy precisa dessa resposta para saber se uma conexão aberta por ele
+// mesmo ficou autenticada e qual packet size foi negociado.
+
+// Tokens adicionais presentes na resposta de login.
+const (
+	tokenInfo          byte = 0xAB
+	tokenFeatureExtAck byte = 0xAE
+	tokenSSPI          byte = 0xED
+	tokenFedAuthInfo   byte = 0xEE
+)
+
+// Tipos de ENVCHANGE relevantes para o login.
+const (
+	envDatabase   byte = 1
+	envPacketSize byte = 4
+)
+
+// LoginResponse contém os campos extraídos da resposta de login do servidor.
+type LoginResponse struct {
+	// Success é true quando o servidor enviou LOGINACK.
+	Success bool
+
+	// TDSVersion negociada (LOGINACK, big-endian no wire).
+	TDSVersion uint32
+
+	// ProgName é o nome do servidor informado no LOGINACK.
+	ProgName string
+
+	// PacketSize negociado via ENVCHANGE (0 se o servidor não enviou).
+	PacketSize int
+
+	// Database inicial da sessão via ENVCHANGE.
+	Database string
+
+	// ErrorNumber e ErrorMessage vêm do primeiro token ERROR, se houver.
+	ErrorNumber  uint32
+	ErrorMessage string
+
+	// FeatureAcks são as feature extensions aceitas pelo servidor
+	// (FEATUREEXTACK).
+	FeatureAcks FeatureSet
+
+	// Interface e ProgVersion do LOGINACK, para o proxy montar o seu.
+	Interface   byte
+	ProgVersion uint32
+}
+
+// ParseLoginResponse faz o parse do payload da resposta ao Login7.
+func ParseLoginResponse(payload []byte) (*LoginResponse, error) {
+	tokens, err := ParseTokens(payload)
+	if err != nil {
+		return nil, fmt.Errorf("login response: %w", err)
+	}
+
+	resp := &LoginResponse{}
+	for i := range tokens {
+		tok := &tokens[i]
+		switch tok.Type {
+		case tokenLoginAck:
+			resp.Success = true
+			parseLoginAck(tok.Data, resp)
+		case tokenError:
+			if resp.ErrorMessage == "" {
+				resp.ErrorNumber, resp.ErrorMessage = tok.ErrorInfo()
+			}
+		case tokenEnvChange:
+			parseLoginEnvChange(tok.Data, resp)
+		case tokenFeatureExtAck:
+			resp.FeatureAcks, _ = tok.FeatureAcks()
+		}
+	}
+
+	return resp, nil
+}
+
+// parseLoginAck extrai TDSVersion e ProgName do corpo de um LOGINACK.
+//
+//	Byte 0:    Interface
+//	Byte 1-4:  TDSVersion (big-endian)
+//	Byte 5:    ProgName length (caracteres)
+//	Byte 6+:   ProgName (UTF-16 LE)
+//	Últimos 4: ProgVersion
+func parseLoginAck(data []byte, resp *LoginResponse) {
+	if len(data) < 6 {
+		return
+	}
+	resp.Interface = data[0]
+	resp.TDSVersion = binary.BigEndian.Uint32(data[1:5])
+	n := int(data[5]) * 2
+	if 6+n <= len(data) {
+		resp.ProgName, _ = decodeUTF16LE(data[6 : 6+n])
+	}
+	if 6+n+4 <= len(data) {
+		resp.ProgVersion = binary.BigEndian.Uint32(data[6+n : 6+n+4])
+	}
+}
+
+// parseErrorData extrai número e mensagem do corpo de um token ERROR/INFO.
+func parseErrorData(data []byte) (uint32, string) {
+	if len(data) < 8 {
+		return 0, ""
+	}
+	number := binary.LittleEndian.Uint32(data[0:4])
+	n := int(binary.LittleEndian.Uint16(data[6:8])) * 2
+	if 8+n > len(data) {
+		return number, ""
+	}
+	msg, _ := decodeUTF16LE(data[8 : 8+n])
+	return number, msg
+}
+
+// parseLoginEnvChange trata os ENVCHANGEs de database e packet size.
+// Ambos carregam NewValue e OldValue como B_VARCHAR.
+func parseLoginEnvChange(data []byte, resp *LoginResponse) {
+	if len(data) < 2 {
+		return
+	}
+	envType := data[0]
+	if envType != envDatabase && envType != envPacketSize {
+		return
+	}
+	n := int(data[1]) * 2
+	if 2+n > len(data) {
+		return
+	}
+	value, err := decodeUTF16LE(data[2 : 2+n])
+	if err != nil {
+		return
+	}
+	switch envType {
+	case envDatabase:
+		resp.Database = value
+	case envPacketSize:
+		if size, err := strconv.Atoi(value); err == nil {
+			resp.PacketSize = size
+		}
+	}
+}
+
+// ── Resposta de login do proxy ──────────────────────────────────────────
+//
+// Com autenticação no proxy, o cliente não recebe a resposta do login de
+// serviço: o proxy monta a sua, com um LOGINACK próprio e apenas os
+// ENVCHANGEs de que o cliente precisa para a sessão (database, idioma,
+// packet size e collation), além do FEATUREEXTACK. INFOs (que citam o
+// login de serviço) e os demais ENVCHANGEs ficam no proxy.
+
+// clientLoginEnvChanges são os ENVCHANGEs repassados ao cliente.
+var clientLoginEnvChanges = map[byte]bool{
+	envDatabase:     true,
+	envLanguage:     true,
+	envPacketSize:   true,
+	envSQLCollation: true,
+}
+
+// BuildClientLoginResponse monta, a partir da resposta de login bem-sucedida
+// do backend, a resposta que o proxy envia ao cliente.
+func BuildClientLoginResponse(payload []byte) ([]byte, error) {
+	tokens, err := ParseTokens(payload)
+	if err != nil {
+		return nil, fmt.Errorf("login response: %w", err)
+	}
+
+	var envChanges, featureAck []byte
+	var resp LoginResponse
+	for i := range tokens {
+		tok := &tokens[i]
+		switch tok.Type {
+		case tokenLoginAck:
+			resp.Success = true
+			parseLoginAck(tok.Data, &resp)
+		case tokenEnvChange:
+			if len(tok.Data) > 0 && clientLoginEnvChanges[tok.Data[0]] {
+				envChanges = appendLengthPrefixedToken(envChanges, tokenEnvChange, tok.Data)
+			}
+		case tokenFeatureExtAck:
+			featureAck = append(append(featureAck, tokenFeatureExtAck), tok.Data...)
+		}
+	}
+	if !resp.Success {
+		return nil, fmt.Errorf("login response has no LOGINACK")
+	}
+
+	out := envChanges
+	out = appendLengthPrefixedToken(out, tokenLoginAck, buildLoginAck(&resp))
+	out = append(out, featureAck...)
+
+	done := make([]byte, 13)
+	done[0] = tokenDone
+	return append(out, done...), nil
+}
+
+// buildLoginAck monta o corpo de um LOGINACK (layout em parseLoginAck).
+func buildLoginAck(resp *LoginResponse) []byte {
+	name := encodeUTF16LE(resp.ProgName)
+	buf := make([]byte, 6, 6+len(name)+4)
+	buf[0] = resp.Interface
+	binary.BigEndian.PutUint32(buf[1:5], resp.TDSVersion)
+	buf[5] = byte(len(name) / 2)
+	buf = append(buf, name...)
+	return binary.BigEndian.AppendUint32(buf, resp.ProgVersion)
+}
+
+// appendLengthPrefixedToken acrescenta a buf um token com tamanho uint16.
+func appendLengthPrefixedToken(buf []byte, tokenType byte, data []byte) []byte {
+	buf = append(buf, tokenType)
+	buf = binary.LittleEndian.AppendUint16(buf, uint16(len(data)))
+	return append(buf, data...)
+}
@@ -0,0 +1,92 @@
+package tds
+
+import (
+	"bytes"
+	"testing"
+)
+
+// envChangeToken monta um ENVCHANGE com NewValue e OldValue B_VARCHAR.
+func envChangeToken(envType byte, newValue, oldValue string) []byte {
+	var body streamBuilder
+	body.u8(envType).bVarchar(newValue).bVarchar(oldValue)
+	return appendLengthPrefixedToken(nil, tokenEnvChange, body.Bytes())
+}
+
+// backendLoginResponse monta uma resposta de login como a do SQL Server.
+func backendLoginResponse() []byte {
+	var b streamBuilder
+	b.raw(envChangeToken(envDatabase, "tenant_db", "master"))
+	b.raw(envChangeToken(envLanguage, "us_english", ""))
+	collation := appendLengthPrefixedToken(nil, tokenEnvChange, []byte{envSQLCollation, 5, 0x09, 0x04, 0xD0, 0x00, 0x34, 0})
+	b.raw(collation)
+	b.raw(envChangeToken(envPacketSize, "8000", "4096"))
+	b.raw(envChangeToken(20, "", "")) // routing / tipos que o cliente não usa
+
+	info := buildErrorToken(5701, 0, "Changed database context to 'tenant_db' for svc_bucket", "rds")
+	info[0] = tokenInfo
+	b.raw(info)
+
+	b.raw(appendLengthPrefixedToken(nil, tokenLoginAck, buildLoginAck(&LoginResponse{
+		Interface: 1, TDSVersion: 0x74000004, ProgName: "Microsoft SQL Server", ProgVersion: 0x0F000FA0,
+	})))
+	b.u8(tokenFeatureExtAck).u8(byte(FeatureUTF8Support)).u32(1).u8(1).u8(featureTerminator)
+	b.done(tokenDone, 0)
+	return b.Bytes()
+}
+
+func TestBuildClientLoginResponse(t *testing.T) {
+	payload := backendLoginResponse()
+
+	out, err := BuildClientLoginResponse(payload)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tokens, err := ParseTokens(out)
+	if err != nil {
+		t.Fatalf("client response does not parse: %v", err)
+	}
+
+	var envTypes []byte
+	for _, tok := range tokens {
+		switch tok.Type {
+		case tokenEnvChange:
+			envTypes = append(envTypes, tok.Data[0])
+		case tokenInfo, tokenError:
+			t.Errorf("token 0x%02X forwarded to the client", tok.Type)
+		}
+	}
+	if want := []byte{envDatabase, envLanguage, envSQLCollation, envPacketSize}; !bytes.Equal(envTypes, want) {
+		t.Errorf("ENVCHANGE types = %v, want %v", envTypes, want)
+	}
+	if last := tokens[len(tokens)-1]; !last.IsDone() || last.Status != 0 {
+		t.Errorf("last token = %+v, want final DONE", last)
+	}
+	if bytes.Contains(out, encodeUTF16LE("svc_bucket")) {
+		t.Error("service login name leaked to the client")
+	}
+
+	resp, err := ParseLoginResponse(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !resp.Success || resp.TDSVersion != 0x74000004 || resp.ProgName != "Microsoft SQL Server" ||
+		resp.ProgVersion != 0x0F000FA0 || resp.Interface != 1 {
+		t.Errorf("LOGINACK = %+v", resp)
+	}
+	if resp.Database != "tenant_db" || resp.PacketSize != 8000 {
+		t.Errorf("session state = %q, %d", resp.Database, resp.PacketSize)
+	}
+	if resp.FeatureAcks.String() != "UTF8_SUPPORT" {
+		t.Errorf("feature acks = %s", resp.FeatureAcks)
+	}
+}
+
+func TestBuildClientLoginResponseFailed(t *testing.T) {
+	payload := append(buildErrorToken(18456, 14, "Login failed for user 'svc_bucket'.", "rds"), buildDoneError()...)
+	if _, err := BuildClientLoginResponse(payload); err == nil {
+		t.Error("built a client response without LOGINACK")
+	}
+	if _, err := BuildClientLoginResponse([]byte{tokenLoginAck, 0x40}); err == nil {
+		t.Error("built a client response from a truncated stream")
+	}
+}
@@ -264,3 +264,12 @@ func BuildPackets(pktType PacketType, payload []byte, packetSize int) [][]byte {
 
 	return packets
 }
+
+// SetResetConnection liga o bit RESETCONNECTION no status do pacote, pedindo
+// ao servidor que execute o equivalente a sp_reset_connection antes de
+// processar a requisição. Só tem efeito no primeiro pacote de uma mensagem.
+func SetResetConnection(pkt []byte) {
+	if len(pkt) >= HeaderSize {
+		pkt[1] |= StatusResetConn
+	}
+}
@@ -1,7 +1,6 @@
 package tds
 
 import (
-	"bytes"
 	"encoding/binary"
 	"strings"
 	"unicode/utf16"
@@ -13,7 +12,7 @@ import (
 // (não devolvida ao pool) por causa de estado no servidor:
 //
 //   - Transações explícitas:     BEGIN TRAN / COMMIT / ROLLBACK
-//   - Prepared statements:       sp_prepare / sp_unprepare
+//   - Cursores:                  sp_cursoropen / sp_cursorprepare / sp_cursorclose
 //   - Bulk load:                 pacotes BULK_LOAD
 //   - Transaction manager:       TM_BEGIN_XACT / TM_COMMIT_XACT / TM_ROLLBACK_XACT
 
@@ -70,11 +69,11 @@ func inspectSQLBatch(payload []byte) PinResult {
 
 	upper := strings.ToUpper(strings.TrimSpace(text))
 
-	// Verificar início de transação.
-	if hasPrefix(upper, "BEGIN TRAN") ||
-		hasPrefix(upper, "BEGIN DISTRIBUTED TRAN") ||
-		hasPrefix(upper, "SET IMPLICIT_TRANSACTIONS ON") ||
-		hasPrefix(upper, "SET XACT_ABORT") {
+	// Verificar início de transação. SET XACT_ABORT é só uma opção de
+	// sessão (reaplicada pelo SessionState), não abre transação.
+	if hasPrefix(upper, "BEGIN TRAN") || hasPrefix(upper, "BEGIN TRANSACTION") ||
+		hasPrefix(upper, "BEGIN DISTRIBUTED TRAN") || hasPrefix(upper, "BEGIN DISTRIBUTED TRANSACTION") ||
+		hasPrefix(upper, "SET IMPLICIT_TRANSACTIONS ON") {
 		return PinResult{Action: PinActionPin, Reason: "transaction"}
 	}
 
@@ -104,12 +103,13 @@ func inspectRPC(payload []byte) PinResult {
 	upper := strings.ToUpper(procName)
 
 	switch upper {
-	case "SP_PREPARE", "SP_CURSOROPEN", "SP_CURSORPREPARE":
+	case "SP_CURSOROPEN", "SP_CURSORPREPARE":
 		return PinResult{Action: PinActionPin, Reason: "prepared"}
-	case "SP_UNPREPARE", "SP_CURSORCLOSE":
+	case "SP_CURSORCLOSE":
 		return PinResult{Action: PinActionUnpin, Reason: "prepared"}
-	case "SP_EXECUTESQL", "SP_EXECUTE":
-		// Estes não alteram o estado de pin — executam dentro de um estado existente.
+	case "SP_PREPARE", "SP_PREPEXEC", "SP_EXECUTE", "SP_UNPREPARE", "SP_EXECUTESQL":
+		// Handles de prepared statement são virtualizados pelo proxy e
+		// recriados em outra conexão quando necessário: não exigem pinning.
 		return PinResult{Action: PinActionNone}
 	}
 
@@ -140,6 +140,31 @@ func inspectTransactionManager(payload []byte) PinResult {
 	return PinResult{Action: PinActionNone}
 }
 
+// LeavesTransactionOpen indica se uma requisição provavelmente deixa uma
+// transação aberta ao terminar: BEGIN_XACT do Transaction Manager, ou um SQL
+// Batch que começa uma transação sem COMMIT/ROLLBACK no mesmo texto.
+// Heurística usada pelo modo statement, que não pode segurar a conexão
+// entre requisições; a resposta do servidor (ENVCHANGE) continua sendo a
+// fonte definitiva.
+func LeavesTransactionOpen(pktType PacketType, payload []byte) bool {
+	result := InspectPacket(pktType, payload)
+	if result.Action != PinActionPin || result.Reason != "transaction" {
+		return false
+	}
+	if pktType != PacketSQLBatch {
+		return true
+	}
+	// O texto inteiro, não só o prefixo usado na detecção de pinning.
+	offset := skipAllHeaders(payload)
+	if offset < 0 {
+		return true
+	}
+	text := payload[offset:]
+	sql, _ := decodeUTF16LE(text[:len(text)&^1])
+	upper := strings.ToUpper(sql)
+	return !strings.Contains(upper, "COMMIT") && !strings.Contains(upper, "ROLLBACK")
+}
+
 // ── Auxiliares ───────────────────────────────────────────────────────────
 
 // skipAllHeaders pula a seção ALL_HEADERS no início de um payload.
@@ -301,6 +326,15 @@ func BuildAttention() []byte {
 	return hdr.Marshal()
 }
 
+// BuildAttentionAck cria a resposta que confirma um Attention: um pacote
+// REPLY com um único token DONE com a flag DONE_ATTN.
+func BuildAttentionAck() []byte {
+	done := make([]byte, 13)
+	done[0] = tokenDone
+	binary.LittleEndian.PutUint16(done[1:3], doneAttn)
+	return BuildPackets(PacketReply, done, 4096)[0]
+}
+
 // ── Inspeção de Resposta ────────────────────────────────────────────────
 
 // Tipos de token em resposta TDS (MS-TDS 2.2.7).
@@ -321,54 +355,69 @@ const (
 	doneSrvError   uint16 = 0x0100
 )
 
-// InspectResponse varre o payload de resposta do servidor em busca de mudanças de estado transacional.
-// Analisa tokens ENVCHANGE (tipo 8 = begin tran, tipo 9 = commit tran,
-// tipo 10 = rollback tran) e tokens DONE com a flag DONE_INXACT.
+// InspectResponse faz o parse de uma resposta completa do servidor e
+// retorna a mudança de estado transacional que ela contém. Respostas que
+// chegam em vários pacotes devem passar por um ResponseParser e InspectTokens.
 func InspectResponse(payload []byte) PinResult {
-	result := PinResult{Action: PinActionNone}
-
-	// Scan for ENVCHANGE tokens related to transactions.
-	for i := 0; i < len(payload)-3; {
-		tokenType := payload[i]
+	tokens, _ := ParseTokens(payload)
+	return InspectTokens(tokens)
+}
 
-		switch tokenType {
-		case tokenEnvChange:
-			if i+3 > len(payload) {
-				return result
-			}
-			envLen := int(binary.LittleEndian.Uint16(payload[i+1 : i+3]))
-			if envLen < 1 || i+3+envLen > len(payload) {
-				return result
-			}
-			envType := payload[i+3]
-			switch envType {
-			case 8: // BEGIN_TXN
-				result = PinResult{Action: PinActionPin, Reason: "transaction"}
-			case 9, 10: // COMMIT_TXN, ROLLBACK_TXN
-				result = PinResult{Action: PinActionUnpin, Reason: "transaction"}
-			}
-			i += 3 + envLen
+// InspectTokens procura ENVCHANGEs transacionais (MS-TDS 2.2.7.9) nos
+// tokens de uma resposta. O último ENVCHANGE transacional prevalece:
+//
+//	8  (Begin Transaction), 11 (Enlist DTC)          → pinar
+//	9  (Commit), 10 (Rollback), 12 (Defect), 17 (Ended) → despinar
+func InspectTokens(tokens []Token) PinResult {
+	result := PinResult{Action: PinActionNone}
+	for i := range tokens {
+		envType, _, ok := tokens[i].EnvChange()
+		if !ok {
+			continue
+		}
+		switch envType {
+		case envBeginTran, envEnlistDTC:
+			result = PinResult{Action: PinActionPin, Reason: "transaction"}
+		case envCommitTran, envRollbackTran, envDefectTran, envTranEnded:
+			result = PinResult{Action: PinActionUnpin, Reason: "transaction"}
+		}
+	}
+	return result
+}
 
-		case tokenDone, tokenDoneProc, tokenDoneInProc:
-			// Token DONE sempre tem 12 bytes (1 token + 2 status + 2 curcmd + 8 rowcount).
-			if i+5 > len(payload) {
-				return result
+// TransactionDescriptor retorna o descritor da transação aberta segundo os
+// ENVCHANGEs transacionais dos tokens: o NewValue do último Begin
+// Transaction (ou Enlist DTC), ou 0 se a transação terminou depois dele. ok
+// é false se os tokens não trazem ENVCHANGE transacional.
+//
+// Requisições enviadas pelo proxy dentro da transação (o ROLLBACK do modo
+// statement, por exemplo) precisam levar esse descritor no ALL_HEADERS.
+func TransactionDescriptor(tokens []Token) (descriptor uint64, ok bool) {
+	for i := range tokens {
+		envType, body, isEnv := tokens[i].EnvChange()
+		if !isEnv {
+			continue
+		}
+		switch envType {
+		case envBeginTran, envEnlistDTC:
+			if len(body) >= 9 && body[0] == 8 {
+				descriptor, ok = binary.LittleEndian.Uint64(body[1:9]), true
 			}
-			// We just skip past for now — ENVCHANGE is more reliable for transaction state.
-			i += 13
-
-		default:
-			// Pular tokens desconhecidos — não é possível parsear todos os tipos de token
-			// de forma confiável, então paramos para evitar interpretar dados incorretamente.
-			return result
+		case envCommitTran, envRollbackTran, envDefectTran, envTranEnded:
+			descriptor, ok = 0, true
 		}
 	}
-
-	return result
+	return descriptor, ok
 }
 
 // ContainsAttentionAck verifica se o payload de resposta contém um token DONE
 // com a flag DONE_ATTN ativada (confirmação de um sinal Attention).
 func ContainsAttentionAck(payload []byte) bool {
-	return bytes.Contains(payload, []byte{tokenDone}) // Verificação simplificada.
+	tokens, _ := ParseTokens(payload)
+	for i := range tokens {
+		if tokens[i].IsAttentionAck() {
+			return true
+		}
+	}
+	return false
 }
@@ -0,0 +1,105 @@
+package tds
+
+import (
+	"encoding/binary"
+	"testing"
+)
+
+func TestInspectSQLBatch(t *testing.T) {
+	tests := []struct {
+		sql    string
+		action PinAction
+		reason string
+	}{
+		{"SELECT 1", PinActionNone, ""},
+		{"begin tran", PinActionPin, "transaction"},
+		{"BEGIN TRANSACTION; UPDATE t SET a = 1", PinActionPin, "transaction"},
+		{"BEGIN DISTRIBUTED TRANSACTION", PinActionPin, "transaction"},
+		{"SET IMPLICIT_TRANSACTIONS ON", PinActionPin, "transaction"},
+		{"SET XACT_ABORT ON", PinActionNone, ""},
+		{"SET XACT_ABORT OFF", PinActionNone, ""},
+		{"COMMIT", PinActionUnpin, "transaction"},
+		{"ROLLBACK TRAN", PinActionUnpin, "transaction"},
+		{"BEGINNING", PinActionNone, ""},
+		{"CREATE TABLE #tmp (id int)", PinActionPin, "temp_table"},
+	}
+	for _, tt := range tests {
+		got := InspectPacket(PacketSQLBatch, BuildSQLBatch(tt.sql))
+		if got.Action != tt.action || got.Reason != tt.reason {
+			t.Errorf("InspectPacket(%q) = %+v, want {%v %q}", tt.sql, got, tt.action, tt.reason)
+		}
+	}
+}
+
+func TestLeavesTransactionOpen(t *testing.T) {
+	tmBegin := make([]byte, 24)
+	binary.LittleEndian.PutUint32(tmBegin[0:4], 22)
+	binary.LittleEndian.PutUint16(tmBegin[22:24], tmBeginXact)
+
+	tests := []struct {
+		name    string
+		pktType PacketType
+		payload []byte
+		want    bool
+	}{
+		{"select", PacketSQLBatch, BuildSQLBatch("SELECT 1"), false},
+		{"begin", PacketSQLBatch, BuildSQLBatch("BEGIN TRAN; UPDATE t SET a = 1"), true},
+		{"begin and commit", PacketSQLBatch, BuildSQLBatch("BEGIN TRAN; UPDATE t SET a = 1; COMMIT"), false},
+		{"begin and rollback", PacketSQLBatch, BuildSQLBatch("BEGIN TRAN; UPDATE t SET a = 1; ROLLBACK"), false},
+		{"implicit transactions", PacketSQLBatch, BuildSQLBatch("SET IMPLICIT_TRANSACTIONS ON"), true},
+		{"xact_abort", PacketSQLBatch, BuildSQLBatch("SET XACT_ABORT ON"), false},
+		{"temp table", PacketSQLBatch, BuildSQLBatch("CREATE TABLE #t (id int)"), false},
+		{"tm begin", PacketTransMgr, tmBegin, true},
+		{"bulk load", PacketBulkLoad, nil, false},
+	}
+	for _, tt := range tests {
+		if got := LeavesTransactionOpen(tt.pktType, tt.payload); got != tt.want {
+			t.Errorf("%s: LeavesTransactionOpen = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestInspectTokens(t *testing.T) {
+	envChange := func(envType byte) Token {
+		return Token{Type: tokenEnvChange, Data: []byte{envType, 8, 1, 2, 3, 4, 5, 6, 7, 8, 0}}
+	}
+	tests := []struct {
+		name   string
+		tokens []Token
+		action PinAction
+	}{
+		{"none", []Token{{Type: tokenDone}}, PinActionNone},
+		{"begin", []Token{envChange(envBeginTran)}, PinActionPin},
+		{"commit", []Token{envChange(envCommitTran)}, PinActionUnpin},
+		{"begin then rollback", []Token{envChange(envBeginTran), envChange(envRollbackTran)}, PinActionUnpin},
+		{"database change", []Token{envChange(envDatabase)}, PinActionNone},
+	}
+	for _, tt := range tests {
+		if got := InspectTokens(tt.tokens); got.Action != tt.action {
+			t.Errorf("%s: InspectTokens = %+v, want action %v", tt.name, got, tt.action)
+		}
+	}
+}
+
+func TestTransactionDescriptor(t *testing.T) {
+	begin := Token{Type: tokenEnvChange, Data: []byte{envBeginTran, 8, 0x11, 0x22, 0x33, 0x44, 0, 0, 0, 0, 0}}
+	commit := Token{Type: tokenEnvChange, Data: []byte{envCommitTran, 0, 8, 0x11, 0x22, 0x33, 0x44, 0, 0, 0, 0}}
+
+	if _, ok := TransactionDescriptor([]Token{{Type: tokenDone}}); ok {
+		t.Error("descriptor reported without transactional ENVCHANGE")
+	}
+	if desc, ok := TransactionDescriptor([]Token{begin}); !ok || desc != 0x44332211 {
+		t.Errorf("begin: got 0x%X, %v", desc, ok)
+	}
+	if desc, ok := TransactionDescriptor([]Token{begin, commit}); !ok || desc != 0 {
+		t.Errorf("begin+commit: got 0x%X, %v", desc, ok)
+	}
+
+	batch := BuildTransactionBatch("ROLLBACK", 0x44332211)
+	if got := binary.LittleEndian.Uint64(batch[10:18]); got != 0x44332211 {
+		t.Errorf("batch descriptor = 0x%X", got)
+	}
+	if got := extractSQLText(batch); got != "ROLLBACK" {
+		t.Errorf("batch text = %q", got)
+	}
+}
@@ -1,6 +1,7 @@
 package tds
 
 import (
+	"bytes"
 	"encoding/binary"
 	"fmt"
 	"io"
@@ -29,6 +30,13 @@ const (
 	EncryptOn     byte = 0x01
 	EncryptNotSup byte = 0x02
 	EncryptReq    byte = 0x03
+	EncryptStrict byte = 0x04 // TDS 8.0: TLS já estabelecido antes do Pre-Login
+)
+
+// Respostas da opção INSTOPT.
+const (
+	instOptMatch    byte = 0x00
+	instOptMismatch byte = 0x01
 )
 
 // PreLoginOption representa uma única opção no pacote Pre-Login.
@@ -111,6 +119,31 @@ func (m *PreLoginMsg) SetEncryption(enc byte) {
 	}
 }
 
+// InstanceName retorna o nome de instância pedido na opção INSTOPT (string
+// MBCS terminada em zero), ou "" se o cliente não pediu uma instância.
+func (m *PreLoginMsg) InstanceName() string {
+	for _, opt := range m.Options {
+		if opt.Token == PreLoginInstOpt {
+			name := opt.Data
+			if i := bytes.IndexByte(name, 0); i >= 0 {
+				name = name[:i]
+			}
+			return string(name)
+		}
+	}
+	return ""
+}
+
+// ClearInstanceName esvazia a opção INSTOPT. O nome de instância pedido ao
+// proxy não existe no backend, que responderia com falha de instância.
+func (m *PreLoginMsg) ClearInstanceName() {
+	for i, opt := range m.Options {
+		if opt.Token == PreLoginInstOpt {
+			m.Options[i].Data = []byte{0x00}
+		}
+	}
+}
+
 // Marshal serializa a mensagem Pre-Login de volta para bytes.
 func (m *PreLoginMsg) Marshal() []byte {
 	// Calcular tamanho do header: 5 bytes por opção + 1 byte terminador.
@@ -141,8 +174,20 @@ func (m *PreLoginMsg) Marshal() []byte {
 }
 
 // BuildPreLoginResponse cria um payload mínimo de resposta Pre-Login.
-// O proxy responde com a mesma versão e ENCRYPT_NOT_SUP para a POC.
-func BuildPreLoginResponse(clientPreLogin *PreLoginMsg) []byte {
+// O proxy responde com a mesma versão do cliente e a criptografia negociada.
+func BuildPreLoginResponse(clientPreLogin *PreLoginMsg, encryption byte) []byte {
+	return preLoginResponse(clientPreLogin, encryption, instOptMatch).Marshal()
+}
+
+// BuildPreLoginInstanceMismatch cria a resposta Pre-Login para um nome de
+// instância desconhecido: INSTOPT 0x01 indica ao cliente que a instância não
+// existe e que ele deve encerrar a conexão.
+func BuildPreLoginInstanceMismatch(clientPreLogin *PreLoginMsg) []byte {
+	return preLoginResponse(clientPreLogin, EncryptNotSup, instOptMismatch).Marshal()
+}
+
+// preLoginResponse monta a resposta Pre-Login do proxy.
+func preLoginResponse(clientPreLogin *PreLoginMsg, encryption, instOpt byte) *PreLoginMsg {
 	resp := &PreLoginMsg{}
 
 	// Copiar versão do cliente ou usar um valor padrão.
@@ -160,13 +205,26 @@ func BuildPreLoginResponse(clientPreLogin *PreLoginMsg) []byte {
 	}
 	resp.Options = append(resp.Options, PreLoginOption{Token: PreLoginVersion, Data: versionData})
 
-	// Responder com criptografia desativada para a POC.
-	resp.Options = append(resp.Options, PreLoginOption{Token: PreLoginEncryption, Data: []byte{EncryptNotSup}})
+	resp.Options = append(resp.Options, PreLoginOption{Token: PreLoginEncryption, Data: []byte{encryption}})
+
+	// INSTOPT: 0x00 se a instância pedida foi aceita pelo proxy.
+	resp.Options = append(resp.Options, PreLoginOption{Token: PreLoginInstOpt, Data: []byte{instOpt}})
 
 	// MARS desativado.
 	resp.Options = append(resp.Options, PreLoginOption{Token: PreLoginMARS, Data: []byte{0x00}})
 
-	return resp.Marshal()
+	return resp
+}
+
+// Clone retorna uma cópia profunda da mensagem Pre-Login.
+func (m *PreLoginMsg) Clone() *PreLoginMsg {
+	c := &PreLoginMsg{Options: make([]PreLoginOption, 0, len(m.Options))}
+	for _, opt := range m.Options {
+		data := make([]byte, len(opt.Data))
+		copy(data, opt.Data)
+		c.Options = append(c.Options, PreLoginOption{Token: opt.Token, Data: data})
+	}
+	return c
 }
 
 // ForwardPreLogin lê uma mensagem Pre-Login do cliente, encaminha ao
@@ -1,6 +1,7 @@
 package tds
 
 import (
+	"errors"
 	"io"
 	"log"
 	"sync"
@@ -11,10 +12,23 @@ import (
 // O relay copia pacotes TDS entre cliente e servidor em ambas as direções.
 // Também inspeciona pacotes para mudanças de estado de pinning de conexão.
 
-// PacketCallback é chamado para cada pacote TDS retransmitido.
-// direction é "client_to_server" ou "server_to_client".
-// Retorne um erro para abortar o relay.
-type PacketCallback func(direction string, pktType PacketType, payload []byte) error
+// Direções do relay, usadas no callback e como label de métricas.
+const (
+	DirectionClientToServer = "client_to_server"
+	DirectionServerToClient = "server_to_client"
+)
+
+// PacketCallback é chamado para cada pacote TDS retransmitido, antes de
+// encaminhá-lo. direction é "client_to_server" ou "server_to_client"; first
+// indica o primeiro pacote de uma mensagem, o único em que ALL_HEADERS e o
+// início do SQL/nome de RPC aparecem.
+// Retorne um erro para abortar o relay, ou ErrSkipPacket para descartar o
+// pacote.
+type PacketCallback func(direction string, hdr *Header, payload []byte, first bool) error
+
+// ErrSkipPacket, retornado pelo callback, descarta o pacote em vez de
+// encaminhá-lo, sem interromper o relay.
+var ErrSkipPacket = errors.New("skip packet")
 
 // Relay realiza relay bidirecional de pacotes TDS entre cliente e backend.
 // Executa até que um dos lados feche a conexão ou ocorra um erro.
@@ -36,13 +50,13 @@ func Relay(client io.ReadWriter, backend io.ReadWriter, callback PacketCallback)
 
 	// Cliente → Servidor
 	go func() {
-		err := relayDirection(client, backend, "client_to_server", callback)
+		err := relayDirection(client, backend, DirectionClientToServer, callback)
 		setResult(err)
 	}()
 
 	// Servidor → Cliente
 	go func() {
-		err := relayDirection(backend, client, "server_to_client", callback)
+		err := relayDirection(backend, client, DirectionServerToClient, callback)
 		setResult(err)
 	}()
 
@@ -52,6 +66,7 @@ func Relay(client io.ReadWriter, backend io.ReadWriter, callback PacketCallback)
 
 // relayDirection copia pacotes TDS de src para dst em uma direção.
 func relayDirection(src io.Reader, dst io.Writer, direction string, callback PacketCallback) error {
+	first := true
 	for {
 		// Ler um pacote TDS completo.
 		hdr, pkt, err := ReadPacket(src)
@@ -62,10 +77,16 @@ func relayDirection(src io.Reader, dst io.Writer, direction string, callback Pac
 		// Invocar callback para inspeção de pinning.
 		if callback != nil {
 			payload := pkt[HeaderSize:]
-			if err := callback(direction, hdr.Type, payload); err != nil {
+			err := callback(direction, hdr, payload, first)
+			if errors.Is(err, ErrSkipPacket) {
+				first = hdr.IsEOM()
+				continue
+			}
+			if err != nil {
 				return err
 			}
 		}
+		first = hdr.IsEOM()
 
 		// Encaminhar pacote ao destino.
 		if _, err := dst.Write(pkt); err != nil {
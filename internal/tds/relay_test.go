@@ -0,0 +1,67 @@
+package tds
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"testing"
+)
+
+// pipeEnd é um lado do relay: lê de r e grava em w.
+type pipeEnd struct {
+	r io.Reader
+	w io.Writer
+}
+
+func (p pipeEnd) Read(b []byte) (int, error)  { return p.r.Read(b) }
+func (p pipeEnd) Write(b []byte) (int, error) { return p.w.Write(b) }
+
+func TestRelaySkipPacket(t *testing.T) {
+	var in bytes.Buffer
+	for _, sql := range []string{"SELECT 1", "SELECT 2", "SELECT 3"} {
+		for _, pkt := range BuildPackets(PacketSQLBatch, BuildSQLBatch(sql), 4096) {
+			in.Write(pkt)
+		}
+	}
+
+	var toBackend bytes.Buffer
+	client := pipeEnd{r: &in, w: io.Discard}
+	backend := pipeEnd{r: blockingReader{}, w: &toBackend}
+
+	var firsts []bool
+	err := Relay(client, backend, func(direction string, hdr *Header, payload []byte, first bool) error {
+		if direction != DirectionClientToServer {
+			return nil
+		}
+		firsts = append(firsts, first)
+		if extractSQLText(payload) == "SELECT 2" {
+			return ErrSkipPacket
+		}
+		return nil
+	})
+	if !errors.Is(err, io.EOF) {
+		t.Fatalf("Relay = %v, want EOF", err)
+	}
+
+	var got []string
+	for toBackend.Len() > 0 {
+		_, payload, _, err := ReadMessage(&toBackend)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got = append(got, extractSQLText(payload))
+	}
+	if len(got) != 2 || got[0] != "SELECT 1" || got[1] != "SELECT 3" {
+		t.Errorf("forwarded %q, want SELECT 1 and SELECT 3", got)
+	}
+	for i, first := range firsts {
+		if !first {
+			t.Errorf("packet %d not reported as the first of its message", i)
+		}
+	}
+}
+
+// blockingReader nunca entrega dados, como um backend sem resposta.
+type blockingReader struct{}
+
+func (blockingReader) Read([]byte) (int, error) { select {} }
@@ -0,0 +1,193 @@
+package tds
+
+import (
+	"encoding/binary"
+	"fmt"
+	"strings"
+)
+
+// ── RPC Request (MS-TDS 2.2.6.6) ────────────────────────────────────────
+//
+// O proxy precisa entender chamadas RPC para virtualizar handles de
+// prepared statements (sp_prepare, sp_prepexec, sp_execute, sp_unprepare):
+// localizar o handle no primeiro parâmetro para reescrevê-lo e guardar os
+// bytes dos parâmetros de definição (@params, @stmt) para preparar o mesmo
+// statement em outra conexão backend.
+//
+//	ALL_HEADERS
+//	NameLenProcID (USHORT): 0xFFFF → ProcID (USHORT); senão nome (UTF-16 LE)
+//	OptionFlags   (USHORT)
+//	ParameterData: Name (B_VARCHAR), StatusFlags (BYTE), TYPE_INFO, valor
+//	[BatchFlag 0xFF / NoExecFlag 0xFE + próxima chamada]
+
+// IDs de procedimentos de prepared statement (MS-TDS 2.2.6.6).
+const (
+	procIDPrepare uint16 = 11
+)
+
+// Flags de status de parâmetro RPC.
+const (
+	paramByRefValue byte = 0x01
+	paramEncrypted  byte = 0x08
+)
+
+// Tipos de dado sem suporte em parâmetros analisados pelo proxy.
+const (
+	typeTVP byte = 0xF3
+)
+
+// RPCParam é um parâmetro de uma chamada RPC.
+type RPCParam struct {
+	Name   string
+	Status byte
+
+	// Value são os bytes do valor sem prefixo de tamanho; nil indica NULL.
+	Value []byte
+
+	// Start e End delimitam o parâmetro inteiro no payload; ValueOffset é
+	// a posição de Value (tipos que não são PLP).
+	Start       int
+	End         int
+	ValueOffset int
+}
+
+// RPCRequest é uma chamada RPC analisada.
+type RPCRequest struct {
+	// ProcName em minúsculas, também para chamadas por ProcID.
+	ProcName string
+
+	// HeadersEnd é o tamanho de ALL_HEADERS no início do payload.
+	HeadersEnd int
+
+	Params []RPCParam
+
+	// Batched indica que o payload traz mais de uma chamada; apenas a
+	// primeira é analisada.
+	Batched bool
+}
+
+// ParseRPC analisa a primeira chamada de um payload RPC_REQUEST.
+func ParseRPC(payload []byte) (*RPCRequest, error) {
+	offset := skipAllHeaders(payload)
+	if offset < 0 {
+		return nil, fmt.Errorf("rpc: payload too short")
+	}
+	req := &RPCRequest{HeadersEnd: offset}
+	r := &tokenReader{b: payload, pos: offset}
+
+	if nameLen := r.uint16(); nameLen == 0xFFFF {
+		req.ProcName = wellKnownProcName(r.uint16())
+	} else {
+		name, _ := decodeUTF16LE(r.bytes(int(nameLen) * 2))
+		req.ProcName = strings.ToLower(name)
+	}
+	r.uint16() // OptionFlags
+
+	for !r.short && r.pos < len(payload) {
+		if flag := payload[r.pos]; flag == 0xFF || flag == 0xFE {
+			req.Batched = true
+			break
+		}
+		param, err := readRPCParam(r)
+		if err != nil {
+			return nil, err
+		}
+		req.Params = append(req.Params, param)
+	}
+	if r.short {
+		return nil, fmt.Errorf("rpc: truncated parameter data")
+	}
+	return req, nil
+}
+
+// readRPCParam lê um parâmetro RPC.
+func readRPCParam(r *tokenReader) (RPCParam, error) {
+	p := RPCParam{Start: r.pos}
+	p.Name = r.bVarchar()
+	p.Status = r.byte()
+	if p.Status&paramEncrypted != 0 {
+		return p, fmt.Errorf("rpc: encrypted parameters are not supported")
+	}
+	if !r.short && r.pos < len(r.b) && r.b[r.pos] == typeTVP {
+		return p, fmt.Errorf("rpc: table-valued parameters are not supported")
+	}
+	ti, err := readTypeInfo(r)
+	if err != nil {
+		return p, fmt.Errorf("rpc: %w", err)
+	}
+
+	if ti.encoding == valText {
+		// Em parâmetros, TEXT/NTEXT/IMAGE não têm TextPtr: LONGLEN com
+		// 0xFFFFFFFF para NULL.
+		if n := r.uint32(); n != 0xFFFFFFFF {
+			p.Value = r.bytes(int(n))
+		}
+	} else if p.Value, err = readValue(r, &ti); err != nil {
+		return p, fmt.Errorf("rpc: %w", err)
+	}
+	p.ValueOffset = r.pos - len(p.Value)
+	p.End = r.pos
+	return p, nil
+}
+
+// BuildPrepareRPC monta um sp_prepare com o handle como primeiro parâmetro
+// (int OUTPUT, NULL) seguido de parâmetros já codificados (@params, @stmt
+// e, opcionalmente, @options). allHeaders é copiado da requisição do cliente
+// para que o descritor de transação seja o da sessão.
+func BuildPrepareRPC(allHeaders []byte, params [][]byte) []byte {
+	buf := append([]byte(nil), allHeaders...)
+	buf = binary.LittleEndian.AppendUint16(buf, 0xFFFF)
+	buf = binary.LittleEndian.AppendUint16(buf, procIDPrepare)
+	buf = binary.LittleEndian.AppendUint16(buf, 0) // OptionFlags
+
+	// @handle: sem nome, OUTPUT, INTN(4), NULL.
+	buf = append(buf, 0, paramByRefValue, typeIntN, 4, 0)
+
+	for _, p := range params {
+		buf = append(buf, p...)
+	}
+	return buf
+}
+
+// PreparedHandle extrai o handle retornado por sp_prepare/sp_prepexec: o
+// primeiro RETURNVALUE da resposta, um int.
+func PreparedHandle(tokens []Token) (handle int32, offset int, ok bool) {
+	for i := range tokens {
+		if tokens[i].Type != tokenReturnValue {
+			continue
+		}
+		if len(tokens[i].Value) != 4 {
+			return 0, 0, false
+		}
+		return int32(binary.LittleEndian.Uint32(tokens[i].Value)), tokens[i].ValueOffset, true
+	}
+	return 0, 0, false
+}
+
+// PatchPayload sobrescreve bytes do payload de uma mensagem já dividida em
+// pacotes. offset é relativo ao payload; base é o offset do payload do
+// primeiro pacote de packets (pacotes anteriores já entregues). Bytes fora
+// dos pacotes informados são ignorados.
+func PatchPayload(packets [][]byte, base, offset int, data []byte) {
+	for _, pkt := range packets {
+		n := len(pkt) - HeaderSize
+		for i, c := range data {
+			pos := offset + i - base
+			if pos >= 0 && pos < n {
+				pkt[HeaderSize+pos] = c
+			}
+		}
+		base += n
+	}
+}
+
+// BuildProcSuccess monta a resposta de um procedimento executado com
+// sucesso sem resultados: RETURNSTATUS 0 seguido de DONEPROC final. Usada
+// quando o proxy atende sozinho uma chamada (ex: sp_unprepare de um handle
+// que não existe na conexão atual).
+func BuildProcSuccess() []byte {
+	payload := []byte{tokenReturnStatus, 0, 0, 0, 0}
+	payload = append(payload, tokenDoneProc)
+	payload = append(payload, make([]byte, 12)...)
+	return buildResponsePackets(payload)
+}
@@ -0,0 +1,234 @@
+package tds
+
+import (
+	"bytes"
+	"encoding/binary"
+	"strings"
+	"testing"
+)
+
+// rpcBuilder monta payloads RPC_REQUEST para os testes.
+type rpcBuilder struct{ streamBuilder }
+
+func newRPC(procID uint16, procName string) *rpcBuilder {
+	b := &rpcBuilder{}
+	b.raw(BuildSQLBatch("")) // ALL_HEADERS
+	if procName == "" {
+		b.u16(0xFFFF).u16(procID)
+	} else {
+		b.u16(uint16(len(procName))).raw(encodeUTF16LE(procName))
+	}
+	b.u16(0) // OptionFlags
+	return b
+}
+
+func (b *rpcBuilder) intParam(name string, status byte, value *int32) *rpcBuilder {
+	b.bVarchar(name).u8(status).u8(typeIntN).u8(4)
+	if value == nil {
+		b.u8(0)
+	} else {
+		b.u8(4).u32(uint32(*value))
+	}
+	return b
+}
+
+func (b *rpcBuilder) nvarcharParam(name, value string) *rpcBuilder {
+	data := encodeUTF16LE(value)
+	b.bVarchar(name).u8(0).u8(typeNVarChar).u16(8000).raw(testCollation)
+	b.u16(uint16(len(data))).raw(data)
+	return b
+}
+
+func (b *rpcBuilder) nvarcharMaxParam(name, value string, chunk int) *rpcBuilder {
+	b.bVarchar(name).u8(0).u8(typeNVarChar).u16(0xFFFF).raw(testCollation)
+	b.plp(encodeUTF16LE(value), chunk)
+	return b
+}
+
+func int32Ptr(v int32) *int32 { return &v }
+
+func TestParseRPC(t *testing.T) {
+	longStmt := strings.Repeat("SELECT * FROM t WHERE id = @p1; ", 40)
+
+	tests := []struct {
+		name    string
+		payload []byte
+		proc    string
+		params  []string
+		values  [][]byte
+		batched bool
+	}{
+		{
+			name:    "sp_execute by id",
+			payload: newRPC(12, "").intParam("", 0, int32Ptr(7)).nvarcharParam("@p1", "abc").Bytes(),
+			proc:    "sp_execute",
+			params:  []string{"", "@p1"},
+			values:  [][]byte{{7, 0, 0, 0}, encodeUTF16LE("abc")},
+		},
+		{
+			name:    "named procedure",
+			payload: newRPC(0, "dbo.GetOrders").intParam("@customer", 0, int32Ptr(-1)).Bytes(),
+			proc:    "dbo.getorders",
+			params:  []string{"@customer"},
+			values:  [][]byte{{0xFF, 0xFF, 0xFF, 0xFF}},
+		},
+		{
+			name:    "null output handle",
+			payload: newRPC(13, "").intParam("@handle", paramByRefValue, nil).nvarcharParam("@params", "").Bytes(),
+			proc:    "sp_prepexec",
+			params:  []string{"@handle", "@params"},
+			values:  [][]byte{nil, {}},
+		},
+		{
+			name:    "plp statement in chunks",
+			payload: newRPC(11, "").intParam("", paramByRefValue, nil).nvarcharMaxParam("@stmt", longStmt, 100).Bytes(),
+			proc:    "sp_prepare",
+			params:  []string{"", "@stmt"},
+			values:  [][]byte{nil, encodeUTF16LE(longStmt)},
+		},
+		{
+			name:    "batched calls",
+			payload: append(newRPC(12, "").intParam("", 0, int32Ptr(1)).Bytes(), 0xFF, 0xFF, 0xFF),
+			proc:    "sp_execute",
+			params:  []string{""},
+			values:  [][]byte{{1, 0, 0, 0}},
+			batched: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req, err := ParseRPC(tt.payload)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if req.ProcName != tt.proc || req.Batched != tt.batched || req.HeadersEnd != 22 {
+				t.Errorf("ParseRPC = %q batched=%v headers=%d", req.ProcName, req.Batched, req.HeadersEnd)
+			}
+			if len(req.Params) != len(tt.params) {
+				t.Fatalf("got %d params, want %d", len(req.Params), len(tt.params))
+			}
+			for i, p := range req.Params {
+				if p.Name != tt.params[i] || !bytes.Equal(p.Value, tt.values[i]) || (p.Value == nil) != (tt.values[i] == nil) {
+					t.Errorf("param %d = %q %x, want %q %x", i, p.Name, p.Value, tt.params[i], tt.values[i])
+				}
+			}
+			// O handle (primeiro parâmetro) é reescrito no lugar via ValueOffset.
+			if h := req.Params[0]; h.Value != nil && !bytes.Equal(tt.payload[h.ValueOffset:h.ValueOffset+len(h.Value)], h.Value) {
+				t.Errorf("ValueOffset %d does not point at the first value", h.ValueOffset)
+			}
+		})
+	}
+}
+
+func TestParseRPCErrors(t *testing.T) {
+	valid := newRPC(12, "").intParam("", 0, int32Ptr(7)).nvarcharParam("@p1", "abc").Bytes()
+
+	encrypted := newRPC(12, "").intParam("", paramEncrypted, int32Ptr(7)).Bytes()
+	tvp := newRPC(0, "dbo.Load")
+	tvp.bVarchar("@rows").u8(0).u8(typeTVP)
+
+	tests := []struct {
+		name    string
+		payload []byte
+	}{
+		{"empty", nil},
+		{"truncated name", valid[:25]},
+		{"truncated value", valid[:len(valid)-3]},
+		{"encrypted parameter", encrypted},
+		{"table-valued parameter", tvp.Bytes()},
+	}
+	for _, tt := range tests {
+		if _, err := ParseRPC(tt.payload); err == nil {
+			t.Errorf("%s: ParseRPC accepted the payload", tt.name)
+		}
+	}
+}
+
+func TestBuildPrepareRPC(t *testing.T) {
+	params := newRPC(0, "x").nvarcharParam("@params", "@p1 int").nvarcharParam("@stmt", "SELECT @p1")
+	defs := params.Bytes()[22+2+2+2:]
+
+	allHeaders := BuildTransactionBatch("", 0x1234)
+	req, err := ParseRPC(BuildPrepareRPC(allHeaders, [][]byte{defs}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if req.ProcName != "sp_prepare" || len(req.Params) != 3 {
+		t.Fatalf("BuildPrepareRPC = %q with %d params", req.ProcName, len(req.Params))
+	}
+	if h := req.Params[0]; h.Status != paramByRefValue || h.Value != nil {
+		t.Errorf("handle param = %+v, want NULL OUTPUT", h)
+	}
+	if string(req.Params[2].Value) != string(encodeUTF16LE("SELECT @p1")) {
+		t.Errorf("@stmt = %x", req.Params[2].Value)
+	}
+}
+
+func TestPreparedHandle(t *testing.T) {
+	b := &streamBuilder{}
+	offset := b.returnValueInt(0, 1073741825)
+	b.done(tokenDoneProc, 0)
+	tokens, err := ParseTokens(b.Bytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+	handle, off, ok := PreparedHandle(tokens)
+	if !ok || handle != 1073741825 || off != offset {
+		t.Errorf("PreparedHandle = %d at %d (%v), want 1073741825 at %d", handle, off, ok, offset)
+	}
+	if _, _, ok := PreparedHandle(tokens[1:]); ok {
+		t.Error("PreparedHandle found a handle without RETURNVALUE")
+	}
+}
+
+// TestPatchPayloadAcrossPackets reescreve o handle de um sp_execute cujo
+// valor fica dividido entre dois pacotes, como o proxy faz ao virtualizar
+// handles.
+func TestPatchPayloadAcrossPackets(t *testing.T) {
+	payload := newRPC(12, "").intParam("", 0, int32Ptr(7)).nvarcharParam("@p1", strings.Repeat("v", 50)).Bytes()
+	req, err := ParseRPC(payload)
+	if err != nil {
+		t.Fatal(err)
+	}
+	valueOffset := req.Params[0].ValueOffset
+	newHandle := binary.LittleEndian.AppendUint32(nil, 0xCAFE)
+
+	for split := valueOffset - 1; split <= valueOffset+4; split++ {
+		packets := BuildPackets(PacketRPCRequest, payload, HeaderSize+split)
+
+		// Patch com todos os pacotes, como antes de enviar a requisição.
+		PatchPayload(packets, 0, valueOffset, newHandle)
+		if got := reassemble(t, packets); !bytes.Equal(got[valueOffset:valueOffset+4], newHandle) {
+			t.Errorf("split %d: handle = %x", split, got[valueOffset:valueOffset+4])
+		}
+
+		// Patch só dos pacotes ainda não entregues: bytes anteriores a base
+		// ficam como estavam.
+		packets = BuildPackets(PacketRPCRequest, payload, HeaderSize+split)
+		base := len(packets[0]) - HeaderSize
+		PatchPayload(packets[1:], base, valueOffset, newHandle)
+		got := reassemble(t, packets)
+		for i := range 4 {
+			want := payload[valueOffset+i]
+			if valueOffset+i >= base {
+				want = newHandle[i]
+			}
+			if got[valueOffset+i] != want {
+				t.Errorf("split %d, base %d: byte %d = %x, want %x", split, base, i, got[valueOffset+i], want)
+			}
+		}
+		if !bytes.Equal(got[:valueOffset], payload[:valueOffset]) || !bytes.Equal(got[valueOffset+4:], payload[valueOffset+4:]) {
+			t.Errorf("split %d: bytes outside the handle changed", split)
+		}
+	}
+}
+
+// reassemble junta os payloads dos pacotes de uma mensagem.
+func reassemble(t *testing.T, packets [][]byte) []byte {
+	t.Helper()
+	var out []byte
+	for _, pkt := range packets {
+		out = append(out, pkt[HeaderSize:]...)
+	}
+	return out
+}
@@ -0,0 +1,162 @@
+package tds
+
+import (
+	"regexp"
+	"strings"
+)
+
+// ── Estado de Sessão ────────────────────────────────────────────────────
+//
+// Parte do estado de uma sessão SQL Server vive na conexão: database
+// corrente, idioma, opções SET. Com pooling, o cliente pode ser servido por
+// outra conexão, que recebe RESETCONNECTION e volta ao estado do login.
+// SessionState registra o que o cliente mudou desde o login para que o
+// proxy reaplique esse estado na nova conexão antes da próxima requisição.
+//
+// Fontes:
+//   - ENVCHANGE do servidor: database (USE), idioma (SET LANGUAGE),
+//     collation e packet size.
+//   - Instruções SET reconhecidas no texto de SQL Batches do cliente.
+
+// Tipos de ENVCHANGE de estado de sessão (MS-TDS 2.2.7.9).
+const (
+	envLanguage     byte = 2
+	envSQLCollation byte = 7
+)
+
+// setStatementPattern reconhece instruções SET que alteram opções da sessão.
+// SET LANGUAGE não entra: o servidor informa a mudança via ENVCHANGE.
+var setStatementPattern = regexp.MustCompile(`(?i)\bSET\s+(?:` +
+	`(TRANSACTION\s+ISOLATION\s+LEVEL)\s+(READ\s+UNCOMMITTED|READ\s+COMMITTED|REPEATABLE\s+READ|SNAPSHOT|SERIALIZABLE)` +
+	`|(DATEFORMAT|DEADLOCK_PRIORITY)\s+([A-Za-z]+|-?\d+)` +
+	`|(DATEFIRST|LOCK_TIMEOUT|TEXTSIZE)\s+(-?\d+)` +
+	`|(ANSI_NULLS|ANSI_NULL_DFLT_ON|ANSI_NULL_DFLT_OFF|ANSI_PADDING|ANSI_WARNINGS|ARITHABORT|ARITHIGNORE` +
+	`|CONCAT_NULL_YIELDS_NULL|CURSOR_CLOSE_ON_COMMIT|NOCOUNT|NUMERIC_ROUNDABORT|QUOTED_IDENTIFIER|XACT_ABORT)\s+(ON|OFF)` +
+	`)\b`)
+
+// moduleDefinitionPattern identifica batches que definem módulos: instruções
+// SET no corpo de uma procedure não afetam a sessão que a cria.
+var moduleDefinitionPattern = regexp.MustCompile(`(?i)\b(CREATE|ALTER)\s+(OR\s+ALTER\s+)?(PROC|PROCEDURE|FUNCTION|TRIGGER)\b`)
+
+// SessionState acumula o estado de sessão definido pelo cliente.
+type SessionState struct {
+	Database   string
+	Language   string
+	Collation  []byte
+	PacketSize string
+
+	// options mapeia a opção (ex: "ANSI_NULLS") para a instrução SET
+	// normalizada; order preserva a ordem em que as opções apareceram.
+	options map[string]string
+	order   []string
+
+	// Estado logo após o login, que o RESETCONNECTION restaura.
+	baseDatabase string
+	baseLanguage string
+}
+
+// NewSessionState cria um estado vazio.
+func NewSessionState() *SessionState {
+	return &SessionState{options: make(map[string]string)}
+}
+
+// ObserveTokens registra os ENVCHANGEs de estado de sessão de uma resposta.
+func (st *SessionState) ObserveTokens(tokens []Token) {
+	for i := range tokens {
+		envType, body, ok := tokens[i].EnvChange()
+		if !ok {
+			continue
+		}
+		switch envType {
+		case envDatabase:
+			st.Database = envNewString(body)
+		case envLanguage:
+			st.Language = envNewString(body)
+		case envPacketSize:
+			st.PacketSize = envNewString(body)
+		case envSQLCollation:
+			if len(body) >= 1 && 1+int(body[0]) <= len(body) {
+				st.Collation = append([]byte(nil), body[1:1+int(body[0])]...)
+			}
+		}
+	}
+}
+
+// ObserveRequest registra as instruções SET reconhecidas em um SQL Batch.
+func (st *SessionState) ObserveRequest(pktType PacketType, payload []byte) {
+	if pktType != PacketSQLBatch {
+		return
+	}
+	offset := skipAllHeaders(payload)
+	if offset < 0 {
+		return
+	}
+	text := payload[offset:]
+	sql, err := decodeUTF16LE(text[:len(text)&^1])
+	if err != nil || moduleDefinitionPattern.MatchString(sql) {
+		return
+	}
+
+	for _, m := range setStatementPattern.FindAllStringSubmatch(sql, -1) {
+		for i := 1; i+1 < len(m); i += 2 {
+			if m[i] == "" {
+				continue
+			}
+			option := strings.ToUpper(strings.Join(strings.Fields(m[i]), " "))
+			value := strings.ToUpper(strings.Join(strings.Fields(m[i+1]), " "))
+			st.setOption(option, "SET "+option+" "+value)
+			break
+		}
+	}
+}
+
+// setOption grava a instrução de uma opção, mantendo a ordem original.
+func (st *SessionState) setOption(option, stmt string) {
+	if _, ok := st.options[option]; !ok {
+		st.order = append(st.order, option)
+	}
+	st.options[option] = stmt
+}
+
+// MarkBaseline registra o estado atual como o estado do login. Chamado após
+// observar a resposta do login inicial.
+func (st *SessionState) MarkBaseline() {
+	st.baseDatabase = st.Database
+	st.baseLanguage = st.Language
+}
+
+// ReplaySQL retorna o batch que reproduz o estado da sessão em uma conexão
+// recém-resetada, ou "" se o estado é o do login. A collation acompanha o
+// database e o packet size é negociado no login, então nenhum dos dois é
+// reaplicado.
+func (st *SessionState) ReplaySQL() string {
+	var stmts []string
+	if st.Database != "" && st.Database != st.baseDatabase {
+		stmts = append(stmts, "USE "+quoteIdentifier(st.Database))
+	}
+	if st.Language != "" && st.Language != st.baseLanguage {
+		stmts = append(stmts, "SET LANGUAGE N'"+strings.ReplaceAll(st.Language, "'", "''")+"'")
+	}
+	for _, option := range st.order {
+		stmts = append(stmts, st.options[option])
+	}
+	return strings.Join(stmts, "; ")
+}
+
+// envNewString extrai o NewValue (B_VARCHAR) do corpo de um ENVCHANGE.
+func envNewString(body []byte) string {
+	if len(body) < 1 {
+		return ""
+	}
+	n := int(body[0]) * 2
+	if 1+n > len(body) {
+		return ""
+	}
+	s, _ := decodeUTF16LE(body[1 : 1+n])
+	return s
+}
+
+// quoteIdentifier delimita um identificador T-SQL com colchetes.
+func quoteIdentifier(name string) string {
+	return "[" + strings.ReplaceAll(name, "]", "]]") + "]"
+}
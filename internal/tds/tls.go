@@ -0,0 +1,263 @@
+package tds
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"net"
+)
+
+// ── TLS dentro do Pre-Login (MS-TDS 2.2.6.5 / 3.3.5.1) ─────────────────
+//
+// No TDS 7.x o handshake TLS não trafega direto no TCP: cada registro TLS
+// do handshake é encapsulado no payload de pacotes PRELOGIN (0x12). Quando
+// o handshake termina, os registros TLS passam a trafegar crus no stream TCP.
+//
+// HandshakeConn implementa essa adaptação para o crypto/tls, tanto do lado
+// servidor (proxy terminando TLS do cliente) quanto do lado cliente (proxy
+// abrindo TLS com o backend).
+//
+// No TDS 8.0 (Encrypt=Strict) não há encapsulamento: o cliente abre o TCP
+// já com um ClientHello (ALPN "tds/8.0") e Pre-Login, Login7 e a fase de
+// dados trafegam dentro do túnel TLS, que pode ser TLS 1.3.
+
+// Modos de criptografia resultantes da negociação Pre-Login.
+const (
+	// EncryptionNone: nenhum pacote é criptografado.
+	EncryptionNone = iota
+	// EncryptionLoginOnly: apenas o Login7 trafega por TLS (ENCRYPT_OFF).
+	EncryptionLoginOnly
+	// EncryptionFull: toda a sessão trafega por TLS (ENCRYPT_ON/REQ).
+	EncryptionFull
+	// EncryptionStrict: TLS do TDS 8.0, estabelecido antes do Pre-Login.
+	EncryptionStrict
+)
+
+// ALPNStrict é o protocolo ALPN negociado no TLS do TDS 8.0.
+const ALPNStrict = "tds/8.0"
+
+// tlsRecordHandshake é o primeiro byte de um registro TLS de handshake
+// (ClientHello). Um Pre-Login começa com o tipo de pacote 0x12.
+const tlsRecordHandshake byte = 0x16
+
+// IsTLSHandshake indica se o primeiro byte recebido em uma conexão é o
+// início de um ClientHello, ou seja, um cliente TDS 8.0 em modo strict.
+func IsTLSHandshake(first byte) bool {
+	return first == tlsRecordHandshake
+}
+
+// HandshakeConn encapsula uma conexão TCP durante o handshake TLS do TDS.
+// Escritas são acumuladas e enviadas como uma única mensagem PRELOGIN quando
+// o crypto/tls passa a ler (fim de um flight) ou em FinishHandshake.
+// Leituras desempacotam o payload de pacotes PRELOGIN/REPLY recebidos.
+type HandshakeConn struct {
+	net.Conn
+
+	wbuf        []byte
+	rbuf        []byte
+	passthrough bool
+}
+
+// NewHandshakeConn cria um HandshakeConn sobre a conexão TCP bruta.
+func NewHandshakeConn(conn net.Conn) *HandshakeConn {
+	return &HandshakeConn{Conn: conn}
+}
+
+// Read lê bytes do handshake TLS desempacotando pacotes TDS.
+// Após FinishHandshake, lê diretamente da conexão subjacente.
+func (c *HandshakeConn) Read(b []byte) (int, error) {
+	if c.passthrough {
+		return c.Conn.Read(b)
+	}
+	if err := c.flush(); err != nil {
+		return 0, err
+	}
+	for len(c.rbuf) == 0 {
+		hdr, pkt, err := ReadPacket(c.Conn)
+		if err != nil {
+			return 0, err
+		}
+		// Endpoints antigos respondem com tipo 0x04 em vez de 0x12.
+		if hdr.Type != PacketPreLogin && hdr.Type != PacketReply {
+			return 0, fmt.Errorf("tls handshake: unexpected packet %s", hdr.Type)
+		}
+		c.rbuf = pkt[HeaderSize:]
+	}
+	n := copy(b, c.rbuf)
+	c.rbuf = c.rbuf[n:]
+	return n, nil
+}
+
+// Write acumula bytes do handshake TLS até o próximo flush.
+// Após FinishHandshake, escreve diretamente na conexão subjacente.
+func (c *HandshakeConn) Write(b []byte) (int, error) {
+	if c.passthrough {
+		return c.Conn.Write(b)
+	}
+	c.wbuf = append(c.wbuf, b...)
+	return len(b), nil
+}
+
+// FinishHandshake envia qualquer flight pendente e passa a repassar os
+// registros TLS crus, como exigido após o fim do handshake.
+func (c *HandshakeConn) FinishHandshake() error {
+	if err := c.flush(); err != nil {
+		return err
+	}
+	c.passthrough = true
+	return nil
+}
+
+// flush envia os bytes acumulados como uma mensagem PRELOGIN completa.
+func (c *HandshakeConn) flush() error {
+	if len(c.wbuf) == 0 {
+		return nil
+	}
+	packets := BuildPackets(PacketPreLogin, c.wbuf, 4096)
+	c.wbuf = nil
+	return WritePackets(c.Conn, packets)
+}
+
+// NegotiateClientEncryption decide a resposta de criptografia do proxy ao
+// Pre-Login do cliente. Sem certificado configurado o proxy só pode
+// responder ENCRYPT_NOT_SUP.
+func NegotiateClientEncryption(client byte, tlsAvailable bool) (response byte, mode int) {
+	if !tlsAvailable {
+		return EncryptNotSup, EncryptionNone
+	}
+	switch client {
+	case EncryptOff:
+		return EncryptOff, EncryptionLoginOnly
+	case EncryptOn, EncryptReq:
+		return EncryptOn, EncryptionFull
+	default:
+		return EncryptNotSup, EncryptionNone
+	}
+}
+
+// ResolveServerEncryption interpreta a resposta Pre-Login de um servidor
+// dado o valor de criptografia enviado pelo proxy.
+func ResolveServerEncryption(sent, response byte) (int, error) {
+	switch response {
+	case EncryptNotSup:
+		if sent == EncryptOn || sent == EncryptReq {
+			return 0, fmt.Errorf("server does not support encryption")
+		}
+		return EncryptionNone, nil
+	case EncryptOff:
+		switch sent {
+		case EncryptOn, EncryptReq:
+			return EncryptionFull, nil
+		case EncryptOff:
+			return EncryptionLoginOnly, nil
+		}
+		return EncryptionNone, nil
+	case EncryptOn, EncryptReq:
+		if sent == EncryptNotSup {
+			return 0, fmt.Errorf("server requires encryption")
+		}
+		return EncryptionFull, nil
+	default:
+		return 0, fmt.Errorf("unknown encryption response 0x%02X", response)
+	}
+}
+
+// ClientHandshake executa o Pre-Login do lado cliente: envia pl ao servidor,
+// lê a resposta e, se a criptografia foi negociada, faz o handshake TLS
+// encapsulado em PRELOGIN usando tlsConfig.
+//
+// Retorna a conexão pela qual o Login7 deve ser enviado e a conexão da fase
+// de dados: com ENCRYPT_OFF apenas o Login7 é cifrado, então as duas diferem.
+func ClientHandshake(conn net.Conn, pl *PreLoginMsg, tlsConfig *tls.Config) (loginConn, dataConn net.Conn, err error) {
+	sent := pl.Encryption()
+	if err := WritePackets(conn, BuildPackets(PacketPreLogin, pl.Marshal(), 4096)); err != nil {
+		return nil, nil, fmt.Errorf("sending prelogin: %w", err)
+	}
+
+	_, respPayload, _, err := ReadMessage(conn)
+	if err != nil {
+		return nil, nil, fmt.Errorf("reading prelogin response: %w", err)
+	}
+	resp, err := ParsePreLogin(respPayload)
+	if err != nil {
+		return nil, nil, fmt.Errorf("parsing prelogin response: %w", err)
+	}
+	mode, err := ResolveServerEncryption(sent, resp.Encryption())
+	if err != nil {
+		return nil, nil, err
+	}
+	if mode == EncryptionNone {
+		return conn, conn, nil
+	}
+
+	hc := NewHandshakeConn(conn)
+	tlsConn := tls.Client(hc, tlsConfig)
+	if err := tlsConn.Handshake(); err != nil {
+		return nil, nil, fmt.Errorf("tls handshake: %w", err)
+	}
+	if err := hc.FinishHandshake(); err != nil {
+		return nil, nil, fmt.Errorf("tls handshake flush: %w", err)
+	}
+
+	if mode == EncryptionFull {
+		return tlsConn, tlsConn, nil
+	}
+	return tlsConn, conn, nil
+}
+
+// StrictClientHandshake abre uma conexão TDS 8.0 com o servidor: handshake
+// TLS direto no TCP com ALPN "tds/8.0" e, dentro do túnel, o Pre-Login com
+// ENCRYPT_STRICT. Retorna a conexão TLS, usada pelo Login7 e pela fase de
+// dados.
+func StrictClientHandshake(conn net.Conn, pl *PreLoginMsg, tlsConfig *tls.Config) (net.Conn, error) {
+	tlsConn := tls.Client(conn, tlsConfig)
+	if err := tlsConn.Handshake(); err != nil {
+		return nil, fmt.Errorf("strict tls handshake: %w", err)
+	}
+
+	pl = pl.Clone()
+	pl.SetEncryption(EncryptStrict)
+	if err := WritePackets(tlsConn, BuildPackets(PacketPreLogin, pl.Marshal(), 4096)); err != nil {
+		return nil, fmt.Errorf("sending prelogin: %w", err)
+	}
+	respType, _, _, err := ReadMessage(tlsConn)
+	if err != nil {
+		return nil, fmt.Errorf("reading prelogin response: %w", err)
+	}
+	if respType != PacketReply {
+		return nil, fmt.Errorf("unexpected prelogin response %s", respType)
+	}
+	return tlsConn, nil
+}
+
+// StrictServerTLSConfig deriva da configuração de terminação TLS do proxy a
+// usada com clientes TDS 8.0: ALPN "tds/8.0" e TLS 1.3 permitido, já que o
+// handshake não passa mais por pacotes PRELOGIN.
+func StrictServerTLSConfig(base *tls.Config) *tls.Config {
+	cfg := base.Clone()
+	cfg.NextProtos = []string{ALPNStrict}
+	cfg.MaxVersion = 0
+	return cfg
+}
+
+// StrictBackendTLSConfig retorna a configuração TLS de conexões TDS 8.0 com
+// um backend.
+func StrictBackendTLSConfig(serverName string, rootCAs *x509.CertPool, skipVerify bool) *tls.Config {
+	cfg := BackendTLSConfig(serverName, rootCAs, skipVerify)
+	cfg.NextProtos = []string{ALPNStrict}
+	cfg.MaxVersion = 0
+	return cfg
+}
+
+// BackendTLSConfig retorna a configuração TLS usada pelo proxy ao abrir
+// conexões com um backend. TLS dentro do Pre-Login só suporta até TLS 1.2.
+// rootCAs nil usa as CAs do sistema.
+func BackendTLSConfig(serverName string, rootCAs *x509.CertPool, skipVerify bool) *tls.Config {
+	return &tls.Config{
+		ServerName:         serverName,
+		RootCAs:            rootCAs,
+		InsecureSkipVerify: skipVerify,
+		MinVersion:         tls.VersionTLS12,
+		MaxVersion:         tls.VersionTLS12,
+	}
+}
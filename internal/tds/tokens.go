@@ -0,0 +1,727 @@
+package tds
+
+import (
+	"encoding/binary"
+	"fmt"
+)
+
+// ── Parser de Token Stream de Resposta (MS-TDS 2.2.7) ─────────────────────
+//
+// As respostas do servidor são uma sequência de tokens que pode atravessar
+// vários pacotes; um token (uma linha com um varchar(max), por exemplo) pode
+// começar em um pacote e terminar no seguinte. ResponseParser recebe os
+// payloads na ordem em que chegam e devolve os tokens completos, guardando
+// o restante até o próximo pacote.
+//
+// Para pular linhas (ROW/NBCROW) é preciso conhecer o tipo de cada coluna,
+// então o parser mantém o último COLMETADATA da resposta. Tipos suportados:
+// todos os tipos fixos, os BYTELEN/USHORTLEN/LONGLEN, PLP (max, XML, UDT)
+// e sql_variant. Colunas com Always Encrypted não são suportadas.
+
+// Tipos de token ainda não declarados em outros arquivos do pacote.
+const (
+	tokenColMetadata  byte = 0x81
+	tokenTabName      byte = 0xA4
+	tokenColInfo      byte = 0xA5
+	tokenOrder        byte = 0xA9
+	tokenReturnStatus byte = 0x79
+	tokenReturnValue  byte = 0xAC
+	tokenRow          byte = 0xD1
+	tokenNBCRow       byte = 0xD2
+	tokenSessionState byte = 0xE4
+)
+
+// Tipos de ENVCHANGE transacionais (MS-TDS 2.2.7.9).
+const (
+	envBeginTran    byte = 8
+	envCommitTran   byte = 9
+	envRollbackTran byte = 10
+	envEnlistDTC    byte = 11
+	envDefectTran   byte = 12
+	envTranEnded    byte = 17
+)
+
+// Token é um token completo de uma resposta do servidor. Apenas os campos
+// relevantes ao tipo são preenchidos.
+type Token struct {
+	// Type é o byte de tipo do token (ex: 0xFD para DONE).
+	Type byte
+
+	// Data é o corpo bruto de tokens com prefixo de tamanho (ERROR, INFO,
+	// LOGINACK, ENVCHANGE, ORDER, FEATUREEXTACK, ...), sem o prefixo.
+	Data []byte
+
+	// Status, CurCmd e RowCount de DONE, DONEPROC e DONEINPROC.
+	Status   uint16
+	CurCmd   uint16
+	RowCount uint64
+
+	// ReturnStatus de RETURNSTATUS.
+	ReturnStatus int32
+
+	// ParamOrdinal, ParamName e Value de RETURNVALUE. Value são os bytes do
+	// valor sem prefixo de tamanho; nil indica NULL. Valores PLP, TEXT/IMAGE
+	// e sql_variant são pulados sem buffer e também ficam nil.
+	ParamOrdinal uint16
+	ParamName    string
+	Value        []byte
+
+	// ValueOffset é a posição de Value no payload da mensagem de resposta
+	// (desde o último End/Reset), para valores que não são PLP. Permite ao
+	// proxy reescrever o valor nos pacotes antes de entregá-los.
+	ValueOffset int
+}
+
+// IsDone indica um token DONE, DONEPROC ou DONEINPROC.
+func (t *Token) IsDone() bool {
+	return t.Type == tokenDone || t.Type == tokenDoneProc || t.Type == tokenDoneInProc
+}
+
+// IsAttentionAck indica um DONE com DONE_ATTN, que confirma um Attention.
+func (t *Token) IsAttentionAck() bool {
+	return t.Type == tokenDone && t.Status&doneAttn != 0
+}
+
+// HasRowCount indica um DONE* cujo RowCount é válido (DONE_COUNT).
+func (t *Token) HasRowCount() bool {
+	return t.IsDone() && t.Status&doneCount != 0
+}
+
+// IsError indica um token ERROR.
+func (t *Token) IsError() bool {
+	return t.Type == tokenError
+}
+
+// ErrorInfo retorna número e mensagem de um token ERROR ou INFO.
+func (t *Token) ErrorInfo() (uint32, string) {
+	return parseErrorData(t.Data)
+}
+
+// EnvChange retorna o tipo de um ENVCHANGE e o corpo após o tipo
+// (NewValue/OldValue, com codificação dependente do tipo).
+func (t *Token) EnvChange() (envType byte, body []byte, ok bool) {
+	if t.Type != tokenEnvChange || len(t.Data) < 1 {
+		return 0, nil, false
+	}
+	return t.Data[0], t.Data[1:], true
+}
+
+// ResponseParser faz o parse incremental de um token stream de resposta.
+// Não é seguro para uso concorrente.
+//
+// Tokens com prefixo de tamanho e metadados são remontados até ficarem
+// completos (no máximo 64 KB, exceto os de prefixo de 4 bytes). Os valores
+// de ROW e NBCROW, que podem ter gigabytes (varchar(max), image), são
+// pulados à medida que chegam: entre pacotes o parser guarda só a coluna
+// corrente e quantos bytes do valor ainda faltam.
+type ResponseParser struct {
+	buf      []byte
+	consumed int // bytes da mensagem já convertidos em tokens ou pulados
+	columns  []typeInfo
+
+	// row é o ROW/NBCROW (ou RETURNVALUE com valor longo) cujos valores
+	// estão sendo pulados; nil entre tokens.
+	row *rowProgress
+}
+
+// rowProgress é o estado de um token cujos valores são pulados sem buffer.
+type rowProgress struct {
+	tok    Token  // emitido quando o último valor termina
+	bitmap []byte // NULL bitmap de NBCROW (nil para ROW)
+	col    int    // próxima coluna cujo valor começa
+	single bool   // RETURNVALUE: um único valor, já em andamento
+	skip   uint64 // bytes do valor (ou do chunk PLP) corrente ainda a pular
+	plp    bool   // o valor corrente é PLP: depois de skip vem outro chunk
+}
+
+// NewResponseParser cria um parser vazio.
+func NewResponseParser() *ResponseParser {
+	return &ResponseParser{}
+}
+
+// Feed adiciona o payload de um pacote e retorna os tokens completados.
+// Um token incompleto fica pendente até o próximo Feed.
+func (p *ResponseParser) Feed(payload []byte) ([]Token, error) {
+	if p.row != nil && len(p.buf) == 0 {
+		// Bytes de um valor que está sendo pulado não passam pelo buffer.
+		n := min(p.row.skip, uint64(len(payload)))
+		payload = payload[n:]
+		p.row.skip -= n
+		p.consumed += int(n)
+	}
+	p.buf = append(p.buf, payload...)
+
+	var tokens []Token
+	for {
+		if p.row != nil {
+			done, err := p.skipRow()
+			if err != nil {
+				return tokens, err
+			}
+			if !done {
+				break
+			}
+			tokens = append(tokens, p.row.tok)
+			p.row = nil
+			continue
+		}
+		if len(p.buf) == 0 {
+			break
+		}
+
+		r := &tokenReader{b: p.buf}
+		tok, err := p.readToken(r)
+		if r.short {
+			// Token incompleto: erros aqui vêm de campos zerados e são
+			// ignorados; o parse é refeito com o próximo pacote.
+			p.row = nil
+			break
+		}
+		if err != nil {
+			return tokens, err
+		}
+		if tok.Type == tokenReturnValue && tok.Value != nil {
+			tok.ValueOffset += p.consumed
+		}
+		p.advance(r.pos)
+		if p.row != nil {
+			// Os valores são pulados em skipRow, que emite o token.
+			p.row.tok = tok
+			continue
+		}
+		tokens = append(tokens, tok)
+	}
+	if len(p.buf) == 0 {
+		p.buf = nil
+	}
+	return tokens, nil
+}
+
+// advance descarta n bytes já processados do início do buffer.
+func (p *ResponseParser) advance(n int) {
+	p.buf = p.buf[n:]
+	p.consumed += n
+}
+
+// skipRow pula os valores do token em p.row com os bytes disponíveis.
+// Retorna true quando o último valor terminou.
+func (p *ResponseParser) skipRow() (bool, error) {
+	row := p.row
+	for {
+		if row.skip > 0 {
+			n := min(row.skip, uint64(len(p.buf)))
+			p.advance(int(n))
+			row.skip -= n
+			if row.skip > 0 {
+				return false, nil
+			}
+		}
+		if row.plp {
+			// Próximo chunk PLP; tamanho 0 encerra o valor.
+			if len(p.buf) < 4 {
+				return false, nil
+			}
+			row.skip = uint64(binary.LittleEndian.Uint32(p.buf))
+			row.plp = row.skip != 0
+			p.advance(4)
+			continue
+		}
+		if row.single || row.col >= len(p.columns) {
+			return true, nil
+		}
+
+		ti := &p.columns[row.col]
+		if row.bitmap != nil && row.bitmap[row.col/8]&(1<<(row.col%8)) != 0 {
+			row.col++ // NULL: sem bytes no stream
+			continue
+		}
+		r := &tokenReader{b: p.buf}
+		n, plp, err := readValueHeader(r, ti)
+		if err != nil {
+			return false, err
+		}
+		if r.short {
+			return false, nil
+		}
+		p.advance(r.pos)
+		row.col++
+		row.skip, row.plp = n, plp
+	}
+}
+
+// End marca o fim da mensagem de resposta (pacote EOM) e reinicia o parser.
+// Retorna erro se sobrou um token incompleto.
+func (p *ResponseParser) End() error {
+	pending := p.Pending()
+	p.Reset()
+	if pending > 0 {
+		return fmt.Errorf("response ended with %d bytes of incomplete token", pending)
+	}
+	return nil
+}
+
+// Pending retorna quantos bytes de um token incompleto aguardam o próximo
+// pacote; durante os valores de um ROW conta também os que faltam do valor
+// corrente (ao menos 1). Zero indica que o stream está em uma fronteira de
+// token.
+func (p *ResponseParser) Pending() int {
+	if p.row != nil {
+		return len(p.buf) + int(min(max(p.row.skip, 1), 1<<30))
+	}
+	return len(p.buf)
+}
+
+// Reset descarta bytes pendentes e metadados de colunas.
+func (p *ResponseParser) Reset() {
+	p.buf = nil
+	p.consumed = 0
+	p.columns = nil
+	p.row = nil
+}
+
+// ParseTokens faz o parse de um token stream completo.
+func ParseTokens(payload []byte) ([]Token, error) {
+	p := NewResponseParser()
+	tokens, err := p.Feed(payload)
+	if err != nil {
+		return tokens, err
+	}
+	return tokens, p.End()
+}
+
+// readToken lê um token do reader. Se os dados acabarem no meio do token,
+// r.short fica true e o token retornado deve ser ignorado.
+func (p *ResponseParser) readToken(r *tokenReader) (Token, error) {
+	tok := Token{Type: r.byte()}
+
+	switch tok.Type {
+	case tokenError, tokenInfo, tokenLoginAck, tokenEnvChange, tokenOrder,
+		tokenSSPI, tokenTabName, tokenColInfo:
+		tok.Data = r.bytes(int(r.uint16()))
+
+	case tokenFedAuthInfo, tokenSessionState:
+		tok.Data = r.bytes(int(r.uint32()))
+
+	case tokenFeatureExtAck:
+		start := r.pos
+		for !r.short {
+			if r.byte() == 0xFF {
+				break
+			}
+			r.bytes(int(r.uint32()))
+		}
+		tok.Data = r.b[start:r.pos]
+
+	case tokenDone, tokenDoneProc, tokenDoneInProc:
+		tok.Status = r.uint16()
+		tok.CurCmd = r.uint16()
+		tok.RowCount = r.uint64()
+
+	case tokenReturnStatus:
+		tok.ReturnStatus = int32(r.uint32())
+
+	case tokenColMetadata:
+		columns, err := readColMetadata(r)
+		if err != nil {
+			return tok, err
+		}
+		if !r.short {
+			p.columns = columns
+		}
+
+	case tokenRow:
+		p.row = &rowProgress{}
+
+	case tokenNBCRow:
+		bitmap := r.bytes((len(p.columns) + 7) / 8)
+		p.row = &rowProgress{bitmap: append([]byte{}, bitmap...)}
+
+	case tokenReturnValue:
+		tok.ParamOrdinal = r.uint16()
+		tok.ParamName = r.bVarchar()
+		r.byte()   // Status
+		r.uint32() // UserType
+		flags := r.uint16()
+		if flags&colFlagEncrypted != 0 {
+			return tok, fmt.Errorf("returnvalue: encrypted parameters are not supported")
+		}
+		ti, err := readTypeInfo(r)
+		if err != nil || r.short {
+			return tok, err
+		}
+		if ti.encoding == valPLP || ti.encoding == valText || ti.encoding == valLongLen {
+			// Valor potencialmente longo: pulado como os de ROW, sem Value.
+			n, plp, err := readValueHeader(r, &ti)
+			if err != nil || r.short {
+				return tok, err
+			}
+			p.row = &rowProgress{single: true, skip: n, plp: plp}
+			return tok, nil
+		}
+		tok.Value, err = readValue(r, &ti)
+		if err != nil {
+			return tok, err
+		}
+		tok.ValueOffset = r.pos - len(tok.Value)
+
+	default:
+		return tok, fmt.Errorf("unsupported response token 0x%02X", tok.Type)
+	}
+
+	return tok, nil
+}
+
+// ── COLMETADATA e TYPE_INFO (MS-TDS 2.2.7.4 / 2.2.5.4) ────────────────────
+
+// Flags de coluna relevantes.
+const (
+	colFlagEncrypted uint16 = 0x0800
+)
+
+// Tipos de dado (MS-TDS 2.2.5.4).
+const (
+	typeNull            byte = 0x1F
+	typeInt1            byte = 0x30
+	typeBit             byte = 0x32
+	typeInt2            byte = 0x34
+	typeInt4            byte = 0x38
+	typeDateTim4        byte = 0x3A
+	typeFlt4            byte = 0x3B
+	typeMoney           byte = 0x3C
+	typeDateTime        byte = 0x3D
+	typeFlt8            byte = 0x3E
+	typeMoney4          byte = 0x7A
+	typeInt8            byte = 0x7F
+	typeGUID            byte = 0x24
+	typeIntN            byte = 0x26
+	typeDecimal         byte = 0x37
+	typeNumeric         byte = 0x3F
+	typeBitN            byte = 0x68
+	typeDecimalN        byte = 0x6A
+	typeNumericN        byte = 0x6C
+	typeFltN            byte = 0x6D
+	typeMoneyN          byte = 0x6E
+	typeDateTimeN       byte = 0x6F
+	typeDateN           byte = 0x28
+	typeTimeN           byte = 0x29
+	typeDateTime2N      byte = 0x2A
+	typeDateTimeOffsetN byte = 0x2B
+	typeChar            byte = 0x2F
+	typeVarChar         byte = 0x27
+	typeBinary          byte = 0x2D
+	typeVarBinary       byte = 0x25
+	typeBigVarBinary    byte = 0xA5
+	typeBigVarChar      byte = 0xA7
+	typeBigBinary       byte = 0xAD
+	typeBigChar         byte = 0xAF
+	typeNVarChar        byte = 0xE7
+	typeNChar           byte = 0xEF
+	typeXML             byte = 0xF1
+	typeUDT             byte = 0xF0
+	typeText            byte = 0x23
+	typeImage           byte = 0x22
+	typeNText           byte = 0x63
+	typeVariant         byte = 0x62
+)
+
+// Marcadores de NULL e tamanho.
+const (
+	plpNull             uint64 = 0xFFFFFFFFFFFFFFFF
+	usVarCharNull       uint16 = 0xFFFF
+	textPtrNull         byte   = 0
+	fixedLenUnsupported        = -1
+)
+
+// Formas de codificação do valor de uma coluna.
+const (
+	valFixed     = iota // tamanho fixo, sem prefixo
+	valByteLen          // prefixo de 1 byte (0 = NULL para tipos N)
+	valUShortLen        // prefixo de 2 bytes (0xFFFF = NULL)
+	valPLP              // Partially Length-Prefixed (tipos max, XML, UDT)
+	valText             // TEXT/NTEXT/IMAGE: TextPtr + Timestamp + 4 bytes de tamanho
+	valLongLen          // prefixo de 4 bytes (sql_variant)
+)
+
+// typeInfo descreve como ler o valor de uma coluna ou parâmetro.
+type typeInfo struct {
+	typ      byte
+	encoding int
+	size     int // tamanho para valFixed
+}
+
+// fixedTypeSize retorna o tamanho de um tipo de tamanho fixo.
+func fixedTypeSize(typ byte) int {
+	switch typ {
+	case typeNull:
+		return 0
+	case typeInt1, typeBit:
+		return 1
+	case typeInt2:
+		return 2
+	case typeInt4, typeDateTim4, typeFlt4, typeMoney4:
+		return 4
+	case typeMoney, typeDateTime, typeFlt8, typeInt8:
+		return 8
+	}
+	return fixedLenUnsupported
+}
+
+// readTypeInfo lê um TYPE_INFO.
+func readTypeInfo(r *tokenReader) (typeInfo, error) {
+	ti := typeInfo{typ: r.byte()}
+
+	if size := fixedTypeSize(ti.typ); size != fixedLenUnsupported {
+		ti.encoding = valFixed
+		ti.size = size
+		return ti, nil
+	}
+
+	switch ti.typ {
+	case typeGUID, typeIntN, typeBitN, typeFltN, typeMoneyN, typeDateTimeN,
+		typeChar, typeVarChar, typeBinary, typeVarBinary:
+		ti.encoding = valByteLen
+		r.byte() // tamanho máximo
+
+	case typeDecimal, typeNumeric, typeDecimalN, typeNumericN:
+		ti.encoding = valByteLen
+		r.bytes(3) // tamanho máximo, precisão, escala
+
+	case typeDateN:
+		ti.encoding = valByteLen
+
+	case typeTimeN, typeDateTime2N, typeDateTimeOffsetN:
+		ti.encoding = valByteLen
+		r.byte() // escala
+
+	case typeBigVarBinary, typeBigBinary:
+		ti.encoding = usLenOrPLP(r.uint16())
+
+	case typeBigVarChar, typeBigChar, typeNVarChar, typeNChar:
+		ti.encoding = usLenOrPLP(r.uint16())
+		r.bytes(5) // collation
+
+	case typeXML:
+		ti.encoding = valPLP
+		if r.byte() != 0 { // schema presente
+			r.bVarchar()
+			r.bVarchar()
+			r.usVarchar()
+		}
+
+	case typeUDT:
+		ti.encoding = valPLP
+		r.uint16() // tamanho máximo
+		r.bVarchar()
+		r.bVarchar()
+		r.bVarchar()
+		r.usVarchar()
+
+	case typeText, typeNText:
+		ti.encoding = valText
+		r.uint32()
+		r.bytes(5) // collation
+
+	case typeImage:
+		ti.encoding = valText
+		r.uint32()
+
+	case typeVariant:
+		ti.encoding = valLongLen
+		r.uint32()
+
+	default:
+		return ti, fmt.Errorf("unsupported data type 0x%02X", ti.typ)
+	}
+	return ti, nil
+}
+
+// usLenOrPLP classifica um tipo USHORTLEN: tamanho máximo 0xFFFF indica (max).
+func usLenOrPLP(maxLen uint16) int {
+	if maxLen == 0xFFFF {
+		return valPLP
+	}
+	return valUShortLen
+}
+
+// readColMetadata lê o corpo de um COLMETADATA.
+//
+//	Count (USHORT, 0xFFFF = sem metadados)
+//	Por coluna: UserType (ULONG), Flags (USHORT), TYPE_INFO,
+//	            [TableName para TEXT/NTEXT/IMAGE], ColName (B_VARCHAR)
+func readColMetadata(r *tokenReader) ([]typeInfo, error) {
+	count := r.uint16()
+	if count == 0xFFFF {
+		return nil, nil
+	}
+	columns := make([]typeInfo, 0, count)
+	for i := 0; i < int(count) && !r.short; i++ {
+		r.uint32() // UserType
+		flags := r.uint16()
+		if flags&colFlagEncrypted != 0 {
+			return nil, fmt.Errorf("colmetadata: encrypted columns are not supported")
+		}
+		ti, err := readTypeInfo(r)
+		if err != nil {
+			return nil, err
+		}
+		if ti.encoding == valText {
+			parts := int(r.byte())
+			for j := 0; j < parts && !r.short; j++ {
+				r.usVarchar()
+			}
+		}
+		r.bVarchar() // ColName
+		columns = append(columns, ti)
+	}
+	return columns, nil
+}
+
+// readValueHeader lê o prefixo de tamanho de um valor codificado conforme ti
+// e retorna quantos bytes do valor seguem (0 para NULL). Com plp, os bytes
+// seguintes são chunks PLP e n é zero.
+func readValueHeader(r *tokenReader, ti *typeInfo) (n uint64, plp bool, err error) {
+	switch ti.encoding {
+	case valFixed:
+		return uint64(ti.size), false, nil
+
+	case valByteLen:
+		return uint64(r.byte()), false, nil
+
+	case valUShortLen:
+		if l := r.uint16(); l != usVarCharNull {
+			return uint64(l), false, nil
+		}
+		return 0, false, nil
+
+	case valLongLen:
+		return uint64(r.uint32()), false, nil
+
+	case valText:
+		ptrLen := r.byte()
+		if ptrLen == textPtrNull {
+			return 0, false, nil
+		}
+		r.bytes(int(ptrLen)) // TextPtr
+		r.bytes(8)           // Timestamp
+		return uint64(r.uint32()), false, nil
+
+	case valPLP:
+		return 0, r.uint64() != plpNull, nil
+	}
+	return 0, false, fmt.Errorf("unknown value encoding %d", ti.encoding)
+}
+
+// readValue lê um valor codificado conforme ti. Retorna nil para NULL.
+// Usado em mensagens completas (RPC) e em valores curtos de RETURNVALUE.
+func readValue(r *tokenReader, ti *typeInfo) ([]byte, error) {
+	switch ti.encoding {
+	case valFixed:
+		return r.bytes(ti.size), nil
+
+	case valByteLen:
+		n := int(r.byte())
+		if n == 0 {
+			return nil, nil
+		}
+		return r.bytes(n), nil
+
+	case valUShortLen:
+		n := r.uint16()
+		if n == usVarCharNull {
+			return nil, nil
+		}
+		return r.bytes(int(n)), nil
+
+	case valLongLen:
+		n := r.uint32()
+		if n == 0 {
+			return nil, nil
+		}
+		return r.bytes(int(n)), nil
+
+	case valText:
+		ptrLen := r.byte()
+		if ptrLen == textPtrNull {
+			return nil, nil
+		}
+		r.bytes(int(ptrLen)) // TextPtr
+		r.bytes(8)           // Timestamp
+		return r.bytes(int(r.uint32())), nil
+
+	case valPLP:
+		total := r.uint64()
+		if total == plpNull {
+			return nil, nil
+		}
+		var value []byte
+		for !r.short {
+			chunk := r.uint32()
+			if chunk == 0 {
+				break
+			}
+			value = append(value, r.bytes(int(chunk))...)
+		}
+		if value == nil {
+			value = []byte{}
+		}
+		return value, nil
+	}
+	return nil, fmt.Errorf("unknown value encoding %d", ti.encoding)
+}
+
+// ── Leitor com detecção de dados insuficientes ──────────────────────────
+
+// tokenReader lê campos little-endian de um buffer. Ao tentar ler além do
+// fim, marca short e passa a devolver zeros, de modo que o parse de um
+// token incompleto termina sem pânico e é refeito quando houver mais dados.
+type tokenReader struct {
+	b     []byte
+	pos   int
+	short bool
+}
+
+// shortRead é devolvido pelas leituras além do fim dos dados. Só as leituras
+// de tamanho fixo (até 8 bytes) recebem zeros; as demais recebem nil, sem
+// alocar o tamanho pedido, que vem do wire.
+var shortRead [8]byte
+
+func (r *tokenReader) bytes(n int) []byte {
+	if r.short || n < 0 || r.pos+n > len(r.b) {
+		r.short = true
+		if n >= 0 && n <= len(shortRead) {
+			return shortRead[:n:n]
+		}
+		return nil
+	}
+	v := r.b[r.pos : r.pos+n]
+	r.pos += n
+	return v
+}
+
+func (r *tokenReader) byte() byte {
+	return r.bytes(1)[0]
+}
+
+func (r *tokenReader) uint16() uint16 {
+	return binary.LittleEndian.Uint16(r.bytes(2))
+}
+
+func (r *tokenReader) uint32() uint32 {
+	return binary.LittleEndian.Uint32(r.bytes(4))
+}
+
+func (r *tokenReader) uint64() uint64 {
+	return binary.LittleEndian.Uint64(r.bytes(8))
+}
+
+// bVarchar lê uma string com tamanho em caracteres de 1 byte.
+func (r *tokenReader) bVarchar() string {
+	s, _ := decodeUTF16LE(r.bytes(int(r.byte()) * 2))
+	return s
+}
+
+// usVarchar lê uma string com tamanho em caracteres de 2 bytes.
+func (r *tokenReader) usVarchar() string {
+	s, _ := decodeUTF16LE(r.bytes(int(r.uint16()) * 2))
+	return s
+}
@@ -0,0 +1,325 @@
+package tds
+
+import (
+	"bytes"
+	"encoding/binary"
+	"runtime"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// ── Construção de token streams para os testes ─────────────────────────
+
+type streamBuilder struct{ bytes.Buffer }
+
+func (b *streamBuilder) u8(v byte) *streamBuilder { b.WriteByte(v); return b }
+
+func (b *streamBuilder) u16(v uint16) *streamBuilder {
+	b.Write(binary.LittleEndian.AppendUint16(nil, v))
+	return b
+}
+
+func (b *streamBuilder) u32(v uint32) *streamBuilder {
+	b.Write(binary.LittleEndian.AppendUint32(nil, v))
+	return b
+}
+
+func (b *streamBuilder) u64(v uint64) *streamBuilder {
+	b.Write(binary.LittleEndian.AppendUint64(nil, v))
+	return b
+}
+
+func (b *streamBuilder) raw(v []byte) *streamBuilder { b.Write(v); return b }
+
+func (b *streamBuilder) bVarchar(s string) *streamBuilder {
+	return b.u8(byte(len(s))).raw(encodeUTF16LE(s))
+}
+
+func (b *streamBuilder) usVarchar(s string) *streamBuilder {
+	return b.u16(uint16(len(s))).raw(encodeUTF16LE(s))
+}
+
+var testCollation = []byte{0x09, 0x04, 0xD0, 0x00, 0x34}
+
+// colMetadata escreve um COLMETADATA com as colunas
+// int, nvarchar(max), varchar(50), image e int NULL-able.
+func (b *streamBuilder) colMetadata() *streamBuilder {
+	b.u8(tokenColMetadata).u16(5)
+	b.u32(0).u16(0).u8(typeInt4).bVarchar("id")
+	b.u32(0).u16(0).u8(typeNVarChar).u16(0xFFFF).raw(testCollation).bVarchar("body")
+	b.u32(0).u16(0).u8(typeBigVarChar).u16(50).raw(testCollation).bVarchar("name")
+	b.u32(0).u16(0).u8(typeImage).u32(0x7FFFFFFF).u8(1).usVarchar("t").bVarchar("blob")
+	b.u32(0).u16(0).u8(typeIntN).u8(4).bVarchar("n")
+	return b
+}
+
+// plp escreve um valor PLP dividido em chunks de chunkSize bytes.
+func (b *streamBuilder) plp(value []byte, chunkSize int) *streamBuilder {
+	b.u64(uint64(len(value)))
+	for len(value) > 0 {
+		n := min(chunkSize, len(value))
+		b.u32(uint32(n)).raw(value[:n])
+		value = value[n:]
+	}
+	return b.u32(0)
+}
+
+// text escreve um valor TEXT/IMAGE com TextPtr e Timestamp.
+func (b *streamBuilder) text(value []byte) *streamBuilder {
+	return b.u8(16).raw(make([]byte, 16)).raw(make([]byte, 8)).u32(uint32(len(value))).raw(value)
+}
+
+func (b *streamBuilder) done(tokenType byte, status uint16) *streamBuilder {
+	return b.u8(tokenType).u16(status).u16(0xC1).u64(1)
+}
+
+// returnValueInt escreve um RETURNVALUE int (INTN) e retorna o offset do valor.
+func (b *streamBuilder) returnValueInt(ordinal uint16, value int32) int {
+	b.u8(tokenReturnValue).u16(ordinal).bVarchar("@handle").u8(1).u32(0).u16(0).u8(typeIntN).u8(4).u8(4)
+	offset := b.Len()
+	b.u32(uint32(value))
+	return offset
+}
+
+// testResponse monta uma resposta com linhas de valores longos e um
+// RETURNVALUE, e retorna o offset do valor do RETURNVALUE.
+func testResponse() ([]byte, int) {
+	b := &streamBuilder{}
+	b.colMetadata()
+
+	body := bytes.Repeat([]byte("x"), 300)
+	b.u8(tokenRow).u32(7).plp(body, 100).u16(3).raw([]byte("abc")).text([]byte("imagebytes")).u8(4).u32(9)
+	b.u8(tokenNBCRow).u8(0b00010110).u32(8).text([]byte("img"))
+	b.u8(tokenRow).u32(9).u64(plpNull).u16(usVarCharNull).u8(textPtrNull).u8(0)
+	b.done(tokenDoneInProc, doneCount)
+
+	offset := b.returnValueInt(0, 42)
+
+	// RETURNVALUE nvarchar(max): pulado sem Value.
+	b.u8(tokenReturnValue).u16(1).bVarchar("@out").u8(1).u32(0).u16(0).u8(typeNVarChar).u16(0xFFFF).raw(testCollation)
+	b.plp(encodeUTF16LE(strings.Repeat("long", 50)), 64)
+
+	b.done(tokenDoneProc, 0)
+	b.done(tokenDone, 0)
+	return b.Bytes(), offset
+}
+
+var testResponseTypes = []byte{
+	tokenColMetadata, tokenRow, tokenNBCRow, tokenRow, tokenDoneInProc,
+	tokenReturnValue, tokenReturnValue, tokenDoneProc, tokenDone,
+}
+
+// feedChunks passa o payload ao parser em pedaços de tamanho chunk.
+func feedChunks(t *testing.T, p *ResponseParser, payload []byte, chunk int) []Token {
+	t.Helper()
+	var tokens []Token
+	for len(payload) > 0 {
+		n := min(chunk, len(payload))
+		got, err := p.Feed(payload[:n])
+		if err != nil {
+			t.Fatalf("chunk %d: Feed: %v", chunk, err)
+		}
+		tokens = append(tokens, got...)
+		payload = payload[n:]
+	}
+	return tokens
+}
+
+func checkResponseTokens(t *testing.T, name string, tokens []Token, valueOffset int) {
+	t.Helper()
+	if len(tokens) != len(testResponseTypes) {
+		t.Fatalf("%s: got %d tokens, want %d", name, len(tokens), len(testResponseTypes))
+	}
+	for i, tok := range tokens {
+		if tok.Type != testResponseTypes[i] {
+			t.Fatalf("%s: token %d = 0x%02X, want 0x%02X", name, i, tok.Type, testResponseTypes[i])
+		}
+	}
+	if tokens[6].Value != nil {
+		t.Errorf("%s: long RETURNVALUE kept %d bytes", name, len(tokens[6].Value))
+	}
+	handle, offset, ok := PreparedHandle(tokens)
+	if !ok || handle != 42 || offset != valueOffset {
+		t.Errorf("%s: PreparedHandle = %d at %d (%v), want 42 at %d", name, handle, offset, ok, valueOffset)
+	}
+	if !tokens[4].HasRowCount() || tokens[4].RowCount != 1 {
+		t.Errorf("%s: DONEINPROC = %+v", name, tokens[4])
+	}
+}
+
+// ── Testes ──────────────────────────────────────────────────────────────
+
+func TestParseTokensComplete(t *testing.T) {
+	payload, valueOffset := testResponse()
+	tokens, err := ParseTokens(payload)
+	if err != nil {
+		t.Fatalf("ParseTokens: %v", err)
+	}
+	checkResponseTokens(t, "complete", tokens, valueOffset)
+}
+
+func TestResponseParserSplitPackets(t *testing.T) {
+	payload, valueOffset := testResponse()
+	for _, chunk := range []int{1, 2, 3, 5, 7, 13, 64, 101, 512} {
+		p := NewResponseParser()
+		tokens := feedChunks(t, p, payload, chunk)
+		checkResponseTokens(t, "chunk "+strconv.Itoa(chunk), tokens, valueOffset)
+		if p.Pending() != 0 {
+			t.Errorf("chunk %d: Pending = %d at end of stream", chunk, p.Pending())
+		}
+		if err := p.End(); err != nil {
+			t.Errorf("chunk %d: End: %v", chunk, err)
+		}
+	}
+}
+
+func TestResponseParserEverySplitPoint(t *testing.T) {
+	payload, valueOffset := testResponse()
+	for i := 1; i < len(payload); i++ {
+		p := NewResponseParser()
+		first, err := p.Feed(payload[:i])
+		if err != nil {
+			t.Fatalf("split %d: %v", i, err)
+		}
+		rest, err := p.Feed(payload[i:])
+		if err != nil {
+			t.Fatalf("split %d: %v", i, err)
+		}
+		checkResponseTokens(t, "split "+strconv.Itoa(i), append(first, rest...), valueOffset)
+		if err := p.End(); err != nil {
+			t.Fatalf("split %d: End: %v", i, err)
+		}
+	}
+}
+
+func TestResponseParserPendingMidRow(t *testing.T) {
+	b := &streamBuilder{}
+	b.colMetadata()
+	b.u8(tokenRow).u32(1).plp(make([]byte, 1000), 500)
+	partial := b.Bytes()
+
+	p := NewResponseParser()
+	tokens, err := p.Feed(partial)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(tokens) != 1 || tokens[0].Type != tokenColMetadata {
+		t.Fatalf("tokens = %v, want only COLMETADATA", tokens)
+	}
+	if p.Pending() == 0 {
+		t.Error("Pending = 0 in the middle of a ROW")
+	}
+	if err := p.End(); err == nil {
+		t.Error("End accepted a response that stopped inside a ROW")
+	}
+}
+
+func TestResponseParserTruncated(t *testing.T) {
+	payload, _ := testResponse()
+	for _, cut := range []int{1, 5, 12, 14, len(payload) / 2} {
+		p := NewResponseParser()
+		if _, err := p.Feed(payload[:len(payload)-cut]); err != nil {
+			t.Fatalf("cut %d: Feed: %v", cut, err)
+		}
+		if err := p.End(); err == nil {
+			t.Errorf("cut %d: End accepted a truncated response", cut)
+		}
+	}
+	if _, err := ParseTokens([]byte{tokenEnvChange, 0x10, 0x00, 1}); err == nil {
+		t.Error("ParseTokens accepted a truncated ENVCHANGE")
+	}
+}
+
+func TestResponseParserUnsupportedToken(t *testing.T) {
+	if _, err := ParseTokens([]byte{0x01, 0x02}); err == nil {
+		t.Error("ParseTokens accepted an unknown token")
+	}
+}
+
+// Valores longos são pulados sem buffer: a memória alocada não cresce com o
+// tamanho do valor nem com o tamanho declarado no wire.
+func TestResponseParserLargeValuesBounded(t *testing.T) {
+	const packet = 4096
+
+	t.Run("plp", func(t *testing.T) {
+		b := &streamBuilder{}
+		b.colMetadata()
+		b.u8(tokenRow).u32(1)
+		head := b.Bytes()
+
+		const chunk = 64 << 10
+		const total = 32 << 20
+		p := NewResponseParser()
+		if _, err := p.Feed(head); err != nil {
+			t.Fatal(err)
+		}
+		allocated := measureAlloc(func() {
+			feedRaw(t, p, binary.LittleEndian.AppendUint64(nil, total))
+			data := make([]byte, packet)
+			for sent := 0; sent < total; sent += chunk {
+				feedRaw(t, p, binary.LittleEndian.AppendUint32(nil, chunk))
+				for n := 0; n < chunk; n += packet {
+					feedRaw(t, p, data)
+				}
+			}
+		})
+		tail := &streamBuilder{}
+		tail.u32(0).u16(1).raw([]byte("a")).text(nil).u8(0)
+		tail.done(tokenDone, 0)
+		tokens, err := p.Feed(tail.Bytes())
+		if err != nil || len(tokens) != 2 || tokens[0].Type != tokenRow {
+			t.Fatalf("tail: %v %v", tokens, err)
+		}
+		if allocated > 4<<20 {
+			t.Errorf("skipping a %d MB value allocated %d bytes", total>>20, allocated)
+		}
+	})
+
+	t.Run("text length from the wire", func(t *testing.T) {
+		b := &streamBuilder{}
+		b.colMetadata()
+		b.u8(tokenRow).u32(1).u64(plpNull).u16(usVarCharNull)
+		b.u8(16).raw(make([]byte, 16)).raw(make([]byte, 8)).u32(0x7FFFFFF0)
+		p := NewResponseParser()
+		allocated := measureAlloc(func() {
+			feedRaw(t, p, b.Bytes())
+			for range 100 {
+				feedRaw(t, p, make([]byte, 100))
+			}
+		})
+		if allocated > 1<<20 {
+			t.Errorf("partial TEXT value allocated %d bytes", allocated)
+		}
+		if p.Pending() == 0 {
+			t.Error("Pending = 0 inside a TEXT value")
+		}
+	})
+
+	t.Run("session state length from the wire", func(t *testing.T) {
+		p := NewResponseParser()
+		allocated := measureAlloc(func() {
+			feedRaw(t, p, []byte{tokenSessionState, 0xF0, 0xFF, 0xFF, 0x7F})
+			feedRaw(t, p, make([]byte, 10))
+		})
+		if allocated > 1<<20 {
+			t.Errorf("partial SESSIONSTATE allocated %d bytes", allocated)
+		}
+	})
+}
+
+func feedRaw(t *testing.T, p *ResponseParser, payload []byte) {
+	t.Helper()
+	if _, err := p.Feed(payload); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func measureAlloc(f func()) uint64 {
+	var before, after runtime.MemStats
+	runtime.GC()
+	runtime.ReadMemStats(&before)
+	f()
+	runtime.ReadMemStats(&after)
+	return after.TotalAlloc - before.TotalAlloc
+}
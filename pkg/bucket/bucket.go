@@ -4,6 +4,17 @@ package bucket
 
 import "time"
 
+// Modos de pinning: por quanto tempo uma sessão segura a conexão backend.
+const (
+	// PinningSession: uma conexão backend por cliente durante toda a sessão.
+	PinningSession = "session"
+	// PinningTransaction: a conexão volta ao pool entre transações.
+	PinningTransaction = "transaction"
+	// PinningStatement: a conexão volta ao pool após cada batch; transações
+	// que atravessam mais de uma requisição são rejeitadas.
+	PinningStatement = "statement"
+)
+
 // Bucket representa um bucket lógico mapeado para uma única instância RDS SQL Server.
 type Bucket struct {
 	ID               string        `yaml:"id"`
@@ -13,10 +24,52 @@ type Bucket struct {
 	Username         string        `yaml:"username"`
 	Password         string        `yaml:"password"`
 	MaxConnections   int           `yaml:"max_connections"`
-	MinIdle          int           `yaml:"min_idle"`
 	MaxIdleTime      time.Duration `yaml:"max_idle_time"`
 	ConnectionTimeout time.Duration `yaml:"connection_timeout"`
 	QueueTimeout     time.Duration `yaml:"queue_timeout"`
+
+	// PinningMode sobrescreve proxy.pinning_mode para este bucket. Workloads
+	// que dependem de temp tables ou prepared handles precisam de "session".
+	PinningMode string `yaml:"pinning_mode"`
+
+	// QueryTimeout limita quanto tempo uma requisição pode ficar em execução
+	// no backend (0 = sem limite). Ao estourar, o proxy cancela a requisição
+	// com ATTENTION e responde ao cliente com um erro TDS. Vale para todos os
+	// modos; no session o prazo conta a partir do fim da mensagem do cliente.
+	QueryTimeout time.Duration `yaml:"query_timeout"`
+
+	// ReplicaOf marca o bucket como réplica de leitura do bucket com este ID.
+	// Sessões com ApplicationIntent=ReadOnly roteadas ao primário vão para
+	// uma réplica saudável, que tem seu próprio limite de conexões.
+	// Database, username e password vazios são herdados do primário.
+	ReplicaOf string `yaml:"replica_of"`
+
+	// ListenPort abre uma porta própria do proxy para o bucket (0 = nenhuma).
+	// Sessões aceitas nela vão direto para o bucket, sem roteamento pelo
+	// Login7: basta trocar a porta na connection string.
+	ListenPort int `yaml:"listen_port"`
+
+	// Endpoints são endereços host:port alternativos do mesmo banco (ex: o
+	// standby de um Multi-AZ), tentados depois de host:port ao abrir uma
+	// conexão de sessão.
+	Endpoints []string `yaml:"endpoints"`
+
+	// DialRetries é quantas vezes a lista de endpoints é percorrida de novo
+	// após todos falharem, com backoff exponencial de DialBackoff e jitter,
+	// sempre dentro de ConnectionTimeout.
+	DialRetries int           `yaml:"dial_retries"`
+	DialBackoff time.Duration `yaml:"dial_backoff"`
+
+	// DNSRefreshInterval é o intervalo entre resoluções do host e dos
+	// endpoints (padrão 5s). Quando os endereços mudam (failover do RDS troca
+	// o IP por trás do nome), as conexões ociosas do bucket são descartadas.
+	// Buckets configurados só com IPs não são resolvidos.
+	DNSRefreshInterval time.Duration `yaml:"dns_refresh_interval"`
+}
+
+// IsReplica indica que o bucket é uma réplica de leitura.
+func (b *Bucket) IsReplica() bool {
+	return b.ReplicaOf != ""
 }
 
 // DSN retorna a string de conexão do SQL Server para este bucket.
@@ -32,6 +85,22 @@ func (b *Bucket) Addr() string {
 	return b.Host + ":" + itoa(b.Port)
 }
 
+// DialAddrs retorna os endereços a tentar ao conectar: host:port seguido
+// dos endpoints alternativos, sem repetições.
+func (b *Bucket) DialAddrs() []string {
+	addrs := []string{b.Addr()}
+	for _, ep := range b.Endpoints {
+		dup := false
+		for _, a := range addrs {
+			dup = dup || a == ep
+		}
+		if !dup {
+			addrs = append(addrs, ep)
+		}
+	}
+	return addrs
+}
+
 // itoa converte um inteiro para string sem importar strconv no nível do pacote.
 func itoa(n int) string {
 	if n == 0 {